@@ -0,0 +1,115 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mrueg/netcupscp-exporter/pkg/metrics"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// alertThresholds are the tunable parts of the generated alerting rules
+type alertThresholds struct {
+	job              string
+	offlineFor       time.Duration
+	diskUsedRatio    float64
+	trafficUsedRatio float64
+	rescueActiveFor  time.Duration
+}
+
+// ruleFile is a Prometheus rule file
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+// ruleGroup is a group of a Prometheus rule file
+type ruleGroup struct {
+	Name  string      `yaml:"name"`
+	Rules []alertRule `yaml:"rules"`
+}
+
+// alertRule is an alerting rule, metrics lists the metric names its expression uses
+type alertRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         model.Duration    `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+	metrics     []string
+}
+
+// alertRules returns the generated alerting rules for the given thresholds
+func alertRules(t alertThresholds) []alertRule {
+	rule := func(name string, expr string, wait time.Duration, severity string, summary string, metricNames ...string) alertRule {
+		return alertRule{
+			Alert:       name,
+			Expr:        expr,
+			For:         model.Duration(wait),
+			Labels:      map[string]string{"severity": severity},
+			Annotations: map[string]string{"summary": summary},
+			metrics:     metricNames,
+		}
+	}
+	return []alertRule{
+		rule("NetcupServerOffline", `scp_server_status == 0`, t.offlineFor, "critical",
+			"vserver {{ $labels.vserver }} is offline", "scp_server_status"),
+		rule("NetcupInterfaceThrottled", `max by (vserver, mac) (scp_interface_throttled) == 1`, 0, "warning",
+			"Traffic of interface {{ $labels.mac }} of vserver {{ $labels.vserver }} is throttled", "scp_interface_throttled"),
+		rule("NetcupDiskAlmostFull", fmt.Sprintf(`scp_disk_used_bytes / scp_disk_capacity_bytes > %g`, t.diskUsedRatio), time.Hour, "warning",
+			"Disk {{ $labels.name }} of vserver {{ $labels.vserver }} is {{ $value | humanizePercentage }} full", "scp_disk_used_bytes", "scp_disk_capacity_bytes"),
+		rule("NetcupTrafficQuotaAlmostExhausted", fmt.Sprintf(`scp_traffic_used_ratio > %g`, t.trafficUsedRatio), 0, "warning",
+			"vserver {{ $labels.vserver }} used {{ $value | humanizePercentage }} of its included traffic", "scp_traffic_used_ratio"),
+		rule("NetcupRescueSystemActive", `max by (vserver) (scp_rescue_active) == 1`, t.rescueActiveFor, "warning",
+			"The rescue system of vserver {{ $labels.vserver }} is still active", "scp_rescue_active"),
+		rule("NetcupRebootRecommended", `max by (vserver) (scp_reboot_recommended) == 1`, 0, "info",
+			"A reboot of vserver {{ $labels.vserver }} is recommended", "scp_reboot_recommended"),
+		rule("NetcupExporterDown", fmt.Sprintf(`up{job=%q} == 0`, t.job), 5*time.Minute, "critical",
+			"The netcup SCP exporter {{ $labels.instance }} can't be scraped"),
+		rule("NetcupAPICredentialsRejected", `scp_api_auth_ok == 0`, 0, "critical",
+			"The SCP webservice rejects the credentials of {{ $labels.instance }}", "scp_api_auth_ok"),
+		rule("NetcupAPIDown", fmt.Sprintf(`up{job=%q} == 1 unless on (job, instance) scp_servers_total`, t.job), 15*time.Minute, "critical",
+			"The SCP webservice can't be reached from {{ $labels.instance }}", "scp_servers_total"),
+	}
+}
+
+// collectorMetricNames returns the names of all metrics the collector exports, generate-alerts and generate-dashboard
+// check the metrics they use against it
+func collectorMetricNames(scpCollector *metrics.ScpCollector) map[string]bool {
+	names := make(map[string]bool)
+	for _, name := range scpCollector.MetricNames() {
+		names[name] = true
+	}
+	return names
+}
+
+// runGenerateAlerts writes a Prometheus rule file with alerts on the metrics of scpCollector.
+// It fails if a rule uses a metric the collector doesn't describe, so the rules can't drift from the metric names.
+func runGenerateAlerts(scpCollector *metrics.ScpCollector, thresholds alertThresholds, w io.Writer) error {
+	if thresholds.diskUsedRatio <= 0 || thresholds.diskUsedRatio > 1 {
+		return fmt.Errorf("--disk-used-ratio must be between 0 and 1, got %g", thresholds.diskUsedRatio)
+	}
+	if thresholds.trafficUsedRatio <= 0 {
+		return fmt.Errorf("--traffic-used-ratio must be positive, got %g", thresholds.trafficUsedRatio)
+	}
+	known := collectorMetricNames(scpCollector)
+	rules := alertRules(thresholds)
+	for _, rule := range rules {
+		for _, name := range rule.metrics {
+			if !known[name] {
+				return fmt.Errorf("alert %s uses unknown metric %s", rule.Alert, name)
+			}
+		}
+	}
+	content, err := yaml.Marshal(ruleFile{Groups: []ruleGroup{{Name: "netcupscp", Rules: rules}}})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
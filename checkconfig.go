@@ -0,0 +1,94 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+// runCheckConfig validates the parts of the configuration only checked when serving and prints a summary of the
+// configuration, it returns the exit code. Everything else has been validated by main before, like at a real startup.
+func runCheckConfig(accounts *probeConfig, w io.Writer) int {
+	if *tlsConfig != "" {
+		if err := web.Validate(*tlsConfig); err != nil {
+			fmt.Fprintf(w, "FAILED: invalid --tls-config: %s\n", err)
+			return 1
+		}
+	}
+	if *remoteWriteURL != "" {
+		if _, err := metricsRemoteWriteClient(); err != nil {
+			fmt.Fprintf(w, "FAILED: invalid remote write configuration: %s\n", err)
+			return 1
+		}
+	}
+
+	api := *apiURL
+	switch {
+	case *mock:
+		api = "bundled demo fixtures"
+	case *fixturesDir != "":
+		api = "fixtures in " + *fixturesDir
+	}
+	names := make([]string, 0, len(accounts.Accounts))
+	for name := range accounts.Accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var collectors []string
+	for _, toggle := range []struct {
+		enabled bool
+		name    string
+	}{
+		{*trafficHistory > 0, fmt.Sprintf("traffic history (%d months)", *trafficHistory)},
+		{*trafficGrace > 0, fmt.Sprintf("previous month grace (%s)", *trafficGrace)},
+		{*plainTraffic, "plain traffic labels"},
+		{*dailyTraffic, "daily traffic"},
+		{len(*includedTraffic) > 0, fmt.Sprintf("included traffic (%d vservers)", len(*includedTraffic))},
+		{*compatServerStatus, "compat server status"},
+		{*compatInterfaces, "compat interface metrics"},
+		{*compatV0, "compat v0 metrics"},
+		{*nativeHistograms, "native histograms"},
+		{*logStateChanges, "state change logs"},
+	} {
+		if toggle.enabled {
+			collectors = append(collectors, toggle.name)
+		}
+	}
+
+	fmt.Fprintln(w, "OK: configuration is valid")
+	fmt.Fprintf(w, "API:            %s (timeout %s)\n", api, *apiTimeout)
+	fmt.Fprintf(w, "Listen:         %s (metrics on %s)\n", strings.Join(*addr, ", "), *metricsPath)
+	fmt.Fprintf(w, "Accounts:       %s\n", orNone(names))
+	fmt.Fprintf(w, "Include:        %s\n", describeRegexp(*includeServers))
+	fmt.Fprintf(w, "Exclude:        %s\n", describeRegexp(*excludeServers))
+	fmt.Fprintf(w, "VServer label:  %s\n", *vserverLabel)
+	fmt.Fprintf(w, "Enabled:        %s\n", orNone(collectors))
+	if *remoteWriteURL != "" {
+		fmt.Fprintf(w, "Remote write:   %s every %s\n", *remoteWriteURL, *remoteWriteInterval)
+	}
+	return 0
+}
+
+// orNone joins values for the summary of runCheckConfig
+func orNone(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
+
+// describeRegexp formats an optional filter for the summary of runCheckConfig
+func describeRegexp(re *regexp.Regexp) string {
+	if re == nil {
+		return "none"
+	}
+	return re.String()
+}
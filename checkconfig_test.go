@@ -0,0 +1,72 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunCheckConfig(t *testing.T) {
+	parseFlags(t, "--mock", "--collector.traffic.history-months=2", "--collector.include=^web-", "--remote-write.url=http://localhost:9090/api/v1/write", "check-config")
+	var output bytes.Buffer
+	if code := runCheckConfig(accountConfig(map[string]string{"customer2": "222222", "customer1": "111111"}), &output); code != 0 {
+		t.Fatalf("expected exit code 0, got %d:\n%s", code, output.String())
+	}
+	want := `OK: configuration is valid
+API:            bundled demo fixtures (timeout 10s)
+Listen:         :9757 (metrics on /metrics)
+Accounts:       customer1, customer2
+Include:        ^web-
+Exclude:        none
+VServer label:  name
+Enabled:        traffic history (2 months), state change logs
+Remote write:   http://localhost:9090/api/v1/write every 1m0s
+`
+	if got := output.String(); got != want {
+		t.Errorf("expected the summary\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestRunCheckConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		args func(t *testing.T) []string
+		err  string
+	}{
+		{
+			name: "invalid TLS config",
+			args: func(t *testing.T) []string {
+				return []string{"--tls-config=" + writeFile(t, "web.yml", "tls_server_config:\n  cert_file: missing.crt\n")}
+			},
+			err: "FAILED: invalid --tls-config",
+		},
+		{
+			name: "remote write with basic auth and bearer token",
+			args: func(t *testing.T) []string {
+				return []string{
+					"--remote-write.url=http://localhost:9090/api/v1/write",
+					"--remote-write.username=user",
+					"--remote-write.password-file=" + writeFile(t, "password", "secret"),
+					"--remote-write.bearer-token-file=" + writeFile(t, "token", "token"),
+				}
+			},
+			err: "FAILED: invalid remote write configuration",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parseFlags(t, append(test.args(t), "--mock", "check-config")...)
+			var output bytes.Buffer
+			if code := runCheckConfig(&probeConfig{}, &output); code != 1 {
+				t.Errorf("expected exit code 1, got %d", code)
+			}
+			if !strings.HasPrefix(output.String(), test.err) {
+				t.Errorf("expected the output to start with %q, got %q", test.err, output.String())
+			}
+		})
+	}
+}
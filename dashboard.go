@@ -0,0 +1,158 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/mrueg/netcupscp-exporter/pkg/metrics"
+)
+
+// dashboardPanel is a panel of the generated dashboard, metrics lists the metric names its queries use
+type dashboardPanel struct {
+	title   string
+	kind    string
+	unit    string
+	width   int
+	height  int
+	metrics []string
+	targets []dashboardTarget
+}
+
+// dashboardTarget is a query of a dashboard panel
+type dashboardTarget struct {
+	expr    string
+	legend  string
+	instant bool
+}
+
+// dashboardPanels are the panels of the generated dashboard, in display order
+var dashboardPanels = []dashboardPanel{
+	{
+		title: "Servers", kind: "table", width: 24, height: 8,
+		metrics: []string{"scp_server_info", "scp_server_status", "scp_cpu_cores", "scp_memory_bytes"},
+		targets: []dashboardTarget{
+			{expr: `scp_server_info{vserver=~"$vserver"}`, instant: true},
+			{expr: `max by (vserver, status) (scp_server_status{vserver=~"$vserver"})`, instant: true},
+			{expr: `scp_cpu_cores{vserver=~"$vserver"}`, instant: true},
+			{expr: `scp_memory_bytes{vserver=~"$vserver"}`, instant: true},
+		},
+	},
+	{
+		// The _total counters keep growing across months, so rates aren't affected by the monthly reset of the API values
+		title: "Traffic", kind: "timeseries", unit: "bytes", width: 12, height: 8,
+		metrics: []string{"scp_traffic_in_bytes_total", "scp_traffic_out_bytes_total"},
+		targets: []dashboardTarget{
+			{expr: `increase(scp_traffic_in_bytes_total{vserver=~"$vserver"}[$__interval])`, legend: "{{vserver}} in"},
+			{expr: `-increase(scp_traffic_out_bytes_total{vserver=~"$vserver"}[$__interval])`, legend: "{{vserver}} out"},
+		},
+	},
+	{
+		title: "Traffic quota used", kind: "bargauge", unit: "percentunit", width: 12, height: 8,
+		metrics: []string{"scp_traffic_used_ratio"},
+		targets: []dashboardTarget{
+			{expr: `scp_traffic_used_ratio{vserver=~"$vserver"}`, legend: "{{vserver}}", instant: true},
+		},
+	},
+	{
+		title: "Disk usage", kind: "bargauge", unit: "percentunit", width: 12, height: 8,
+		metrics: []string{"scp_disk_used_bytes", "scp_disk_capacity_bytes"},
+		targets: []dashboardTarget{
+			{expr: `scp_disk_used_bytes{vserver=~"$vserver"} / scp_disk_capacity_bytes{vserver=~"$vserver"}`, legend: "{{vserver}} {{name}}", instant: true},
+		},
+	},
+	{
+		title: "Interface throttled", kind: "state-timeline", width: 12, height: 8,
+		metrics: []string{"scp_interface_throttled"},
+		targets: []dashboardTarget{
+			{expr: `max by (vserver, mac) (scp_interface_throttled{vserver=~"$vserver"})`, legend: "{{vserver}} {{mac}}"},
+		},
+	},
+	{
+		title: "Rescue system and reboot recommendation", kind: "state-timeline", width: 12, height: 8,
+		metrics: []string{"scp_rescue_active", "scp_reboot_recommended"},
+		targets: []dashboardTarget{
+			{expr: `max by (vserver) (scp_rescue_active{vserver=~"$vserver"})`, legend: "{{vserver}} rescue"},
+			{expr: `max by (vserver) (scp_reboot_recommended{vserver=~"$vserver"})`, legend: "{{vserver}} reboot"},
+		},
+	},
+	{
+		title: "API", kind: "timeseries", unit: "s", width: 12, height: 8,
+		metrics: []string{"scp_api_request_duration_seconds", "scp_api_auth_ok"},
+		targets: []dashboardTarget{
+			{expr: `sum by (endpoint) (rate(scp_api_request_duration_seconds_sum[$__rate_interval])) / sum by (endpoint) (rate(scp_api_request_duration_seconds_count[$__rate_interval]))`, legend: "{{endpoint}}"},
+			{expr: `scp_api_auth_ok`, legend: "auth ok"},
+		},
+	},
+}
+
+// runGenerateDashboard writes a Grafana dashboard for the metrics of scpCollector querying the datasource with the given UID.
+// It fails if a panel queries a metric the collector doesn't describe, so the dashboard can't drift from the metric names.
+func runGenerateDashboard(scpCollector *metrics.ScpCollector, datasourceUID string, w io.Writer) error {
+	known := collectorMetricNames(scpCollector)
+	datasource := map[string]any{"type": "prometheus", "uid": datasourceUID}
+	panels := make([]map[string]any, 0, len(dashboardPanels))
+	x, y := 0, 0
+	for i, panel := range dashboardPanels {
+		for _, name := range panel.metrics {
+			if !known[name] {
+				return fmt.Errorf("panel %q uses unknown metric %s", panel.title, name)
+			}
+		}
+		if x+panel.width > 24 {
+			x, y = 0, y+panel.height
+		}
+		targets := make([]map[string]any, 0, len(panel.targets))
+		for j, target := range panel.targets {
+			query := map[string]any{
+				"datasource":   datasource,
+				"expr":         target.expr,
+				"legendFormat": target.legend,
+				"refId":        string(rune('A' + j)),
+			}
+			if target.instant {
+				query["instant"] = true
+				query["range"] = false
+				if panel.kind == "table" {
+					query["format"] = "table"
+				}
+			}
+			targets = append(targets, query)
+		}
+		panels = append(panels, map[string]any{
+			"id":          i + 1,
+			"title":       panel.title,
+			"type":        panel.kind,
+			"datasource":  datasource,
+			"gridPos":     map[string]int{"x": x, "y": y, "w": panel.width, "h": panel.height},
+			"fieldConfig": map[string]any{"defaults": map[string]any{"unit": panel.unit}, "overrides": []any{}},
+			"targets":     targets,
+		})
+		x += panel.width
+	}
+	dashboard := map[string]any{
+		"title":         "Netcup SCP",
+		"uid":           "netcupscp-exporter",
+		"schemaVersion": 39,
+		"time":          map[string]string{"from": "now-7d", "to": "now"},
+		"refresh":       "5m",
+		"tags":          []string{"netcup"},
+		"templating": map[string]any{"list": []map[string]any{{
+			"name":       "vserver",
+			"type":       "query",
+			"datasource": datasource,
+			"query":      map[string]any{"query": "label_values(scp_server_info, vserver)", "refId": "vserver"},
+			"includeAll": true,
+			"multi":      true,
+			"current":    map[string]any{"text": "All", "value": "$__all"},
+		}}},
+		"panels": panels,
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(dashboard)
+}
@@ -0,0 +1,91 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrueg/netcupscp-exporter/pkg/metrics"
+)
+
+// generateCollector returns a collector for the metric descriptions, like generate-dashboard and generate-alerts use
+func generateCollector(t *testing.T) *metrics.ScpCollector {
+	t.Helper()
+	collector, err := metrics.NewScpCollector(nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collector
+}
+
+// assertGolden fails the test unless got matches the content of the golden file. After an intended change of the output,
+// regenerate it with the default flags, e.g. netcupscp-exporter generate-alerts > testdata/alerts.golden.yml
+func assertGolden(t *testing.T, golden string, got []byte) {
+	t.Helper()
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s, got:\n%s", golden, got)
+	}
+}
+
+func TestGenerateDashboard(t *testing.T) {
+	var output bytes.Buffer
+	if err := runGenerateDashboard(generateCollector(t), "prometheus", &output); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "testdata/dashboard.golden.json", output.Bytes())
+}
+
+func TestGenerateDashboardUnknownMetric(t *testing.T) {
+	panels := dashboardPanels
+	t.Cleanup(func() { dashboardPanels = panels })
+	dashboardPanels = append(panels[:len(panels):len(panels)], dashboardPanel{title: "Renamed", metrics: []string{"scp_renamed"}})
+	err := runGenerateDashboard(generateCollector(t), "prometheus", io.Discard)
+	if err == nil || !strings.Contains(err.Error(), `panel "Renamed" uses unknown metric scp_renamed`) {
+		t.Errorf("expected the unknown metric to be rejected, got %v", err)
+	}
+}
+
+func TestGenerateAlerts(t *testing.T) {
+	var output bytes.Buffer
+	if err := runGenerateAlerts(generateCollector(t), alertThresholds{
+		job:              "netcupscp",
+		offlineFor:       10 * time.Minute,
+		diskUsedRatio:    0.9,
+		trafficUsedRatio: 0.9,
+		rescueActiveFor:  time.Hour,
+	}, &output); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "testdata/alerts.golden.yml", output.Bytes())
+}
+
+func TestGenerateAlertsRejectsThresholds(t *testing.T) {
+	tests := []struct {
+		name       string
+		thresholds alertThresholds
+		err        string
+	}{
+		{"disk ratio above 1", alertThresholds{diskUsedRatio: 90, trafficUsedRatio: 0.9}, "--disk-used-ratio must be between 0 and 1"},
+		{"traffic ratio 0", alertThresholds{diskUsedRatio: 0.9}, "--traffic-used-ratio must be positive"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := runGenerateAlerts(generateCollector(t), test.thresholds, io.Discard)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mrueg/netcupscp-exporter/pkg/metrics"
+)
+
+// runListServers prints the vservers of the account as table or JSON and returns the exit code, like runCheck
+func runListServers(scpCollector *metrics.ScpCollector, output string, w io.Writer) int {
+	servers, err := scpCollector.ListServers(context.Background())
+	switch {
+	case metrics.IsAuthError(err):
+		fmt.Fprintf(w, "FAILED: the webservice rejected the request, check the credentials: %s\n", err)
+		return 2
+	case err != nil:
+		fmt.Fprintf(w, "FAILED: unable to list the vservers: %s\n", err)
+		return 1
+	}
+	if output == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(servers); err != nil {
+			return 1
+		}
+		return 0
+	}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tNICKNAME\tSTATUS\tIPS")
+	for _, server := range servers {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", server.Name, server.Nickname, server.Status, strings.Join(server.IPs, ","))
+	}
+	if err := table.Flush(); err != nil {
+		return 1
+	}
+	return 0
+}
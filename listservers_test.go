@@ -0,0 +1,88 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/hooklift/gowsdl/soap"
+	"github.com/mrueg/netcupscp-exporter/pkg/fixtures"
+	"github.com/mrueg/netcupscp-exporter/pkg/metrics"
+	"github.com/mrueg/netcupscp-exporter/pkg/scpclient"
+)
+
+// rejectingClient answers from the demo fixtures, but rejects the credentials when listing the vservers
+type rejectingClient struct {
+	metrics.Client
+}
+
+// GetVServersContext implements metrics.Client
+func (rejectingClient) GetVServersContext(ctx context.Context, request *scpclient.GetVServers) (*scpclient.GetVServersResponse, error) {
+	return nil, &soap.SOAPFault{Code: "S:Server", String: "validation error on field 'password'"}
+}
+
+// listServers runs list-servers with the given output against client and returns the exit code and the output
+func listServers(t *testing.T, client metrics.Client, format string) (int, string) {
+	t.Helper()
+	collector, err := metrics.NewScpCollector(client, slog.New(slog.NewTextHandler(io.Discard, nil)), metrics.WithCredentials("123456", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	code := runListServers(collector, format, &output)
+	return code, output.String()
+}
+
+func TestRunListServersTable(t *testing.T) {
+	code, output := listServers(t, fixtures.NewClient(fixtures.Demo()), "table")
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d:\n%s", code, output)
+	}
+	want := `NAME                  NICKNAME  STATUS   IPS
+v2202410000000000001  web-1     online   192.0.2.10,2001:db8:10::/64
+v2202410000000000002  db-1      online   192.0.2.20,2001:db8:20::/64
+v2202410000000000003            offline  192.0.2.30,2001:db8:30::/64
+`
+	if output != want {
+		t.Errorf("expected the table\n%s\ngot\n%s", want, output)
+	}
+}
+
+func TestRunListServersJSON(t *testing.T) {
+	code, output := listServers(t, fixtures.NewClient(fixtures.Demo()), "json")
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d:\n%s", code, output)
+	}
+	var servers []metrics.InventoryServer
+	if err := json.Unmarshal([]byte(output), &servers); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, server := range servers {
+		names = append(names, server.Name+"/"+server.Nickname+"/"+server.Status)
+	}
+	if got, want := strings.Join(names, ","), "v2202410000000000001/web-1/online,v2202410000000000002/db-1/online,v2202410000000000003//offline"; got != want {
+		t.Errorf("expected the servers %s, got %s", want, got)
+	}
+	if disk := servers[0].Disks[0]; disk.CapacityBytes != 256<<30 {
+		t.Errorf("expected the disk capacity in bytes, got %d", disk.CapacityBytes)
+	}
+}
+
+func TestRunListServersRejected(t *testing.T) {
+	code, output := listServers(t, rejectingClient{fixtures.NewClient(fixtures.Demo())}, "table")
+	if code != 2 {
+		t.Errorf("expected exit code 2 for rejected credentials, got %d", code)
+	}
+	if !strings.HasPrefix(output, "FAILED: the webservice rejected the request") {
+		t.Errorf("expected the rejected credentials to be reported, got %q", output)
+	}
+}
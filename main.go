@@ -6,18 +6,33 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
+	"github.com/alecthomas/units"
 	"github.com/hooklift/gowsdl/soap"
+	"github.com/mrueg/netcupscp-exporter/pkg/fixtures"
 	"github.com/mrueg/netcupscp-exporter/pkg/metrics"
+	"github.com/mrueg/netcupscp-exporter/pkg/remotewrite"
 	"github.com/mrueg/netcupscp-exporter/pkg/scpclient"
 	"github.com/prometheus/client_golang/prometheus"
 	cversion "github.com/prometheus/client_golang/prometheus/collectors/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/promslog"
 	"github.com/prometheus/common/promslog/flag"
 	"github.com/prometheus/common/version"
@@ -25,31 +40,234 @@ import (
 )
 
 var (
-	loginName = kingpin.Flag("login-name", "User ID").Envar("SCP_LOGINNAME").Default("").String()
-	password  = kingpin.Flag("password", "API Password").Envar("SCP_PASSWORD").Default("").String()
-	addr      = kingpin.Flag("listen-address", "The address to listen on for HTTP requests.").Envar("SCP_LISTENADDRESS").Default(":9757").String()
-	tlsConfig = kingpin.Flag("tls-config", "Path to TLS config file.").Envar("SCP_TLSCONFIG").Default("").String()
+	loginName     = kingpin.Flag("login-name", "User ID").Envar("SCP_LOGINNAME").Default("").String()
+	loginNameFile = kingpin.Flag("login-name-file", "Path to a file containing the User ID, takes precedence over --login-name.").Envar("SCP_LOGINNAME_FILE").Default("").String()
+	password      = kingpin.Flag("password", "API Password").Envar("SCP_PASSWORD").Default("").String()
+	passwordFile  = kingpin.Flag("password-file", "Path to a file containing the API Password, takes precedence over --password.").Envar("SCP_PASSWORD_FILE").Default("").String()
+	addr          = kingpin.Flag("listen-address", "The address to listen on for HTTP requests, can be repeated.").Envar("SCP_LISTENADDRESS").Default(":9757").Strings()
+	tlsConfig     = kingpin.Flag("tls-config", "Path to TLS config file.").Envar("SCP_TLSCONFIG").Default("").String()
+	check         = kingpin.Flag("check", "Verify that the webservice accepts the credentials, print a summary and exit (1 on network errors, 2 if the credentials are rejected).").Default("false").Bool()
+	once          = kingpin.Flag("once", "Collect once, write the metrics to --output.file and exit.").Default("false").Bool()
+	outputFile    = kingpin.Flag("output.file", "File to write the metrics to in --once mode, e.g. for the node_exporter textfile collector.").Envar("SCP_OUTPUT_FILE").Default("").String()
+	metricsPath   = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Envar("SCP_WEB_TELEMETRYPATH").Default("/metrics").String()
+	configFile    = kingpin.Flag("config.file", "Path to a YAML file with the accounts that can be scraped via /probe?account=<name>.").Envar("SCP_CONFIG_FILE").Default("").String()
+	apiTimeout    = kingpin.Flag("api.timeout", "Timeout of a single call to the SCP webservice, including connecting and the TLS handshake.").Envar("SCP_APITIMEOUT").Default("10s").Duration()
+	apiIPProtocol = kingpin.Flag("api.ip-protocol", "IP protocol used to connect to the SCP webservice, one of any, ipv4 or ipv6.").Envar("SCP_APIIPPROTOCOL").Default("any").Enum("any", "ipv4", "ipv6")
+	apiURL        = kingpin.Flag("api.url", "URL of the SCP webservice.").Envar("SCP_APIURL").Default(netcupWSUrl).String()
+	serverListTTL = kingpin.Flag("cache.server-list-ttl", "Time the listing of the vservers is reused for before calling getVServers again, 0 lists them in every collection. Scrape with ?refresh_servers=1 to list them right away.").Envar("SCP_CACHE_SERVERLISTTTL").Default("0s").Duration()
+	fixturesDir   = kingpin.Flag("api.fixtures-dir", "Answer the calls to the SCP webservice from the JSON fixtures in this directory instead, no credentials needed.").Envar("SCP_APIFIXTURESDIR").Default("").String()
+	recordDir     = kingpin.Flag("api.record-dir", "Save the responses of the SCP webservice to this directory as fixtures for --api.fixtures-dir, credentials are redacted.").Envar("SCP_APIRECORDDIR").Default("").String()
+	mock          = kingpin.Flag("mock", "Answer the calls to the SCP webservice from the bundled demo fixtures instead, no credentials needed.").Default("false").Bool()
+
+	remoteWriteURL             = kingpin.Flag("remote-write.url", "Push the metrics to this Prometheus remote write endpoint in addition to serving them.").Envar("SCP_REMOTEWRITE_URL").Default("").String()
+	remoteWriteInterval        = kingpin.Flag("remote-write.interval", "Interval between two pushes via remote write.").Envar("SCP_REMOTEWRITE_INTERVAL").Default("1m").Duration()
+	remoteWriteUsername        = kingpin.Flag("remote-write.username", "Username for basic authentication against the remote write endpoint.").Envar("SCP_REMOTEWRITE_USERNAME").Default("").String()
+	remoteWritePasswordFile    = kingpin.Flag("remote-write.password-file", "Path to a file containing the password for basic authentication against the remote write endpoint.").Envar("SCP_REMOTEWRITE_PASSWORD_FILE").Default("").String()
+	remoteWriteBearerTokenFile = kingpin.Flag("remote-write.bearer-token-file", "Path to a file containing the bearer token for the remote write endpoint.").Envar("SCP_REMOTEWRITE_BEARERTOKEN_FILE").Default("").String()
+
+	disableSelfMetrics = kingpin.Flag("web.disable-exporter-metrics", "Exclude metrics about the exporter itself (go_*, process_*).").Envar("SCP_WEB_DISABLEEXPORTERMETRICS").Default("false").Bool()
+	enableDebug        = kingpin.Flag("web.enable-debug", "Serve "+debugCollectPath+", which runs a collection and reports the outcome of every webservice call as JSON.").Envar("SCP_WEB_ENABLEDEBUG").Default("false").Bool()
+	logStateChanges    = kingpin.Flag("log.state-changes", "Log changes of the server status, rescue system, reboot recommendation and interface throttling between collections.").Envar("SCP_LOG_STATECHANGES").Default("true").Bool()
+	nativeHistograms   = kingpin.Flag("metrics.native-histograms", "Export histograms as native histograms in addition to the classic buckets.").Envar("SCP_METRICS_NATIVEHISTOGRAMS").Default("false").Bool()
+	constLabels        = kingpin.Flag("metrics.const-label", "Label added to all exported metrics as <name>=<value>, can be repeated.").Envar("SCP_METRICS_CONSTLABEL").StringMap()
+	dropLabels         = kingpin.Flag("metrics.drop-label", "Label exported with an empty value as <metric>:<label>, e.g. scp_server_info:nickname, can be repeated.").Envar("SCP_METRICS_DROPLABEL").Strings()
+	compatServerStatus = kingpin.Flag("compat.server-status-nickname", "Keep the nickname label on scp_server_status (deprecated, use scp_server_info).").Envar("SCP_COMPAT_SERVERSTATUSNICKNAME").Default("false").Bool()
+	compatInterfaces   = kingpin.Flag("compat.interface-metrics", "Export scp_interface_throttled once per IP with all interface attributes as labels (deprecated, use scp_interface_info and scp_interface_address_info).").Envar("SCP_COMPAT_INTERFACEMETRICS").Default("false").Bool()
+	compatV0           = kingpin.Flag("compat.v0-metrics", "Export all metrics whose labels changed since the first release in their old shape, implies --compat.server-status-nickname and --compat.interface-metrics and drops the ip_type and mac labels of scp_ip_info.").Envar("SCP_COMPAT_V0METRICS").Default("false").Bool()
+	trafficHistory     = kingpin.Flag("collector.traffic.history-months", "Number of previous months to export monthly traffic for, in addition to the current one.").Envar("SCP_COLLECTOR_TRAFFIC_HISTORYMONTHS").Default("0").Int()
+	trafficGrace       = kingpin.Flag("collector.traffic.previous-month-grace", "Time after the start of a month during which the final traffic of the previous month is exported as well (e.g. 24h).").Envar("SCP_COLLECTOR_TRAFFIC_PREVIOUSMONTHGRACE").Default("0s").Duration()
+	plainTraffic       = kingpin.Flag("collector.traffic.plain-labels", "Export the monthly traffic of the current month without month and year labels, the values reset when a new month starts.").Envar("SCP_COLLECTOR_TRAFFIC_PLAINLABELS").Default("false").Bool()
+	includedTraffic    = kingpin.Flag("collector.traffic.included", "Monthly traffic included in the plan of a vserver as <vserver>=<size> (e.g. v2200000000000000000=80TB), can be repeated.").Envar("SCP_COLLECTOR_TRAFFIC_INCLUDED").StringMap()
+	dailyTraffic       = kingpin.Flag("collector.traffic.daily", "Export the traffic of today and yesterday.").Envar("SCP_COLLECTOR_TRAFFIC_DAILY").Default("false").Bool()
+	includeServers     = kingpin.Flag("collector.include", "Only export metrics for vservers whose name or nickname matches this regular expression.").Envar("SCP_COLLECTOR_INCLUDE").Regexp()
+	excludeServers     = kingpin.Flag("collector.exclude", "Don't export metrics for vservers whose name or nickname matches this regular expression.").Envar("SCP_COLLECTOR_EXCLUDE").Regexp()
+	collectMinInterval = kingpin.Flag("collect.min-interval", "Answer scrapes with the metrics of the previous collection if it completed less than this long ago, 0 collects on every scrape.").Envar("SCP_COLLECT_MININTERVAL").Default("15s").Duration()
+	vserverLabel       = kingpin.Flag("vserver-label", "Identifier used as vserver label, one of name, nickname or nickname-fallback-name.").Envar("SCP_VSERVERLABEL").Default(metrics.VServerLabelName).Enum(metrics.VServerLabelName, metrics.VServerLabelNickname, metrics.VServerLabelNicknameFallbackName)
+)
+
+var (
+	serveCommand       = kingpin.Command("serve", "Serve the metrics via HTTP (default).").Default()
+	listServersCommand = kingpin.Command("list-servers", "Print the vservers of the account and exit, e.g. to check the credentials or write --collector.include / --collector.exclude filters.")
+	listServersOutput  = listServersCommand.Flag("output", "Output format, one of table or json.").Default("table").Enum("table", "json")
+	dashboardCommand   = kingpin.Command("generate-dashboard", "Print a Grafana dashboard for the exported metrics and exit.")
+	dashboardSource    = dashboardCommand.Flag("datasource-uid", "UID of the Prometheus datasource the dashboard queries.").Default("prometheus").String()
+	alertsCommand      = kingpin.Command("generate-alerts", "Print a Prometheus rule file with alerts on the exported metrics and exit.")
+	alertsJob          = alertsCommand.Flag("job", "Job name the exporter is scraped with.").Default("netcupscp").String()
+	alertsOfflineFor   = alertsCommand.Flag("offline-for", "Time a vserver has to be offline before alerting.").Default("10m").Duration()
+	alertsDiskUsed     = alertsCommand.Flag("disk-used-ratio", "Ratio of used disk space to alert on.").Default("0.9").Float64()
+	alertsTrafficUsed  = alertsCommand.Flag("traffic-used-ratio", "Ratio of used to included monthly traffic to alert on.").Default("0.9").Float64()
+	alertsRescueFor    = alertsCommand.Flag("rescue-active-for", "Time the rescue system has to be active before alerting.").Default("1h").Duration()
+	checkConfigCommand = kingpin.Command("check-config", "Validate the flags and --config.file like at startup, print a summary and exit without contacting the webservice.")
 )
 
 const netcupWSUrl = "https://www.servercontrolpanel.de/SCP/WSEndUser" //nolint:gosec
 
+const inventoryPath = "/api/v1/inventory"
+
+const debugCollectPath = "/debug/collect"
+
 func main() {
 
 	promslogConfig := &promslog.Config{}
 	flag.AddFlags(kingpin.CommandLine, promslogConfig)
 	kingpin.Version(version.Version + " git " + version.Revision)
-	kingpin.Parse()
+	command := kingpin.Parse()
 
 	var logger *slog.Logger
 
-	var metricsPath = "/metrics"
 	logger = promslog.New(promslogConfig)
 	logger.Debug("Starting SCP Exporter version " + version.Version + " git " + version.Revision)
-	client := soap.NewClient(netcupWSUrl)
-	wsclient := scpclient.NewWSEndUser(client)
-	scpCollector := metrics.NewScpCollector(wsclient, logger, loginName, password)
-	prometheus.DefaultRegisterer.MustRegister(scpCollector)
-	prometheus.DefaultRegisterer.MustRegister(cversion.NewCollector("scp"))
+	if command == dashboardCommand.FullCommand() || command == alertsCommand.FullCommand() {
+		// Only the metric descriptions are needed, no credentials or API client
+		scpCollector, err := metrics.NewScpCollector(nil, logger)
+		if err == nil && command == dashboardCommand.FullCommand() {
+			err = runGenerateDashboard(scpCollector, *dashboardSource, os.Stdout)
+		} else if err == nil {
+			err = runGenerateAlerts(scpCollector, alertThresholds{
+				job:              *alertsJob,
+				offlineFor:       *alertsOfflineFor,
+				diskUsedRatio:    *alertsDiskUsed,
+				trafficUsedRatio: *alertsTrafficUsed,
+				rescueActiveFor:  *alertsRescueFor,
+			}, os.Stdout)
+		}
+		if err != nil {
+			logger.Error("failed to generate output", "command", command, "error", err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	scpLoginName, scpPassword, err := loadCredentials(logger)
+	if err != nil {
+		logger.Error("failed to read credentials", "error", err.Error())
+		os.Exit(1)
+	}
+	startup := flagConfig(command, scpLoginName, scpPassword)
+	if err := validateConfig(startup); err != nil {
+		logger.Error("invalid configuration", "error", err.Error())
+		os.Exit(1)
+	}
+	trafficIncluded, err := parseIncludedTraffic(*includedTraffic)
+	if err != nil {
+		logger.Error("failed to parse included traffic", "error", err.Error())
+		os.Exit(1)
+	}
+	labels, err := parseConstLabels(*constLabels)
+	if err != nil {
+		logger.Error("failed to parse const labels", "error", err.Error())
+		os.Exit(1)
+	}
+	dropped, err := parseDroppedLabels(*dropLabels)
+	if err != nil {
+		logger.Error("failed to parse dropped labels", "error", err.Error())
+		os.Exit(1)
+	}
+	rateLimits := metrics.NewRateLimits(labels)
+	var wsclient metrics.Client
+	switch {
+	case *mock:
+		wsclient = fixtures.NewClient(fixtures.Demo())
+	case *fixturesDir != "":
+		wsclient = fixtures.NewClient(os.DirFS(*fixturesDir))
+	default:
+		wsclient = newAPIClient(*apiURL, *apiTimeout, *apiIPProtocol, rateLimits, logger)
+		if *recordDir != "" {
+			wsclient = fixtures.NewRecorder(wsclient, *recordDir, logger)
+		}
+	}
+	opts := collectorOptions(trafficIncluded, labels, dropped)
+	newCollector := func(loginName string, password string, extra ...metrics.Option) (*metrics.ScpCollector, error) {
+		return metrics.NewScpCollector(wsclient, logger, slices.Concat(opts, []metrics.Option{metrics.WithCredentials(loginName, password)}, extra)...)
+	}
+	// The rate limits are observed for all calls of the exporter, they are only exported once, without account label
+	scpCollector, err := newCollector(scpLoginName, scpPassword, metrics.WithRateLimits(rateLimits))
+	if err != nil {
+		logger.Error("invalid collector configuration", "error", err.Error())
+		os.Exit(1)
+	}
+	var accounts atomic.Pointer[probeConfig]
+	accounts.Store(&probeConfig{})
+	if *configFile != "" {
+		probes, err := loadProbeConfig(*configFile)
+		if err != nil {
+			logger.Error("failed to load config file", "error", err.Error())
+			os.Exit(1)
+		}
+		accounts.Store(probes)
+	}
+	var targets atomic.Pointer[probeTargets]
+	initialTargets, err := newProbeTargets(accounts.Load(), nil, newCollector)
+	if err != nil {
+		logger.Error("invalid probe configuration", "error", err.Error())
+		os.Exit(1)
+	}
+	targets.Store(&initialTargets)
+	if command == checkConfigCommand.FullCommand() {
+		os.Exit(runCheckConfig(accounts.Load(), os.Stdout))
+	}
+	if command == listServersCommand.FullCommand() {
+		os.Exit(runListServers(scpCollector, *listServersOutput, os.Stdout))
+	}
+	if *check {
+		os.Exit(runCheck(scpCollector))
+	}
+	if *once {
+		if err := runOnce(scpCollector, *outputFile); err != nil {
+			logger.Error("failed to collect metrics", "error", err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	var registerer prometheus.Registerer = prometheus.DefaultRegisterer
+	var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+	if *disableSelfMetrics {
+		registry := prometheus.NewRegistry()
+		registerer, gatherer = registry, registry
+	}
+	probeOnly := startup.probeOnly()
+	if probeOnly {
+		// Without credentials the default collector would only report failed logins
+		logger.Info("No credentials set, only the accounts of --config.file are collected via " + probePath)
+		registerer.MustRegister(rateLimits)
+	} else if err := registerer.Register(scpCollector); err != nil {
+		logger.Error("failed to register collector", "error", err.Error())
+		os.Exit(1)
+	}
+	prometheus.WrapRegistererWith(labels, registerer).MustRegister(cversion.NewCollector("scp"))
+	reloadSuccessful := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "scp_config_last_reload_successful",
+		Help:        "Whether the last credential reload attempt was successful",
+		ConstLabels: labels,
+	})
+	reloadTimestamp := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "scp_config_last_reload_timestamp_seconds",
+		Help:        "Timestamp of the last successful credential reload",
+		ConstLabels: labels,
+	})
+	registerer.MustRegister(reloadSuccessful, reloadTimestamp)
+	reloadSuccessful.Set(1)
+	reloadTimestamp.SetToCurrentTime()
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if err := reloadCredentials(logger, probeOnly, scpCollector, &accounts, &targets, newCollector); err != nil {
+				logger.Error("failed to reload credentials", "error", err.Error())
+				reloadSuccessful.Set(0)
+				continue
+			}
+			reloadSuccessful.Set(1)
+			reloadTimestamp.SetToCurrentTime()
+		}
+	}()
+	if *remoteWriteURL != "" {
+		remoteWriteClient, err := metricsRemoteWriteClient()
+		if err != nil {
+			logger.Error("failed to create remote write client", "error", err.Error())
+			os.Exit(1)
+		}
+		go remotewrite.Run(context.Background(), gatherer, remoteWriteClient, *remoteWriteInterval, logger)
+	}
 	metricsServer := http.Server{
 		ReadHeaderTimeout: 5 * time.Second}
 
@@ -59,26 +277,40 @@ func main() {
 		Version:     version.Version + " git " + version.Revision,
 		Links: []web.LandingLinks{
 			{
-				Address: metricsPath,
+				Address: *metricsPath,
 				Text:    "Metrics",
 			},
 		},
 	}
+	if !probeOnly {
+		landingConfig.Links = append(landingConfig.Links, web.LandingLinks{Address: inventoryPath, Text: "Inventory"})
+	}
 	landingPage, err := web.NewLandingPage(landingConfig)
 	if err != nil {
 		logger.Error("failed to create landing page", "error", err.Error())
 		os.Exit(1)
 	}
-	http.Handle(metricsPath, promhttp.HandlerFor(
-		prometheus.DefaultGatherer,
+	metricsHandler := promhttp.HandlerFor(
+		gatherer,
 		promhttp.HandlerOpts{
 			// Opt into OpenMetrics to support exemplars.
 			EnableOpenMetrics: true,
-		}))
+		})
+	if probeOnly {
+		// Only the metrics of the exporter itself
+		http.Handle(*metricsPath, metricsHandler)
+	} else {
+		http.Handle(*metricsPath, refreshServersHandler(scpCollector, metricsHandler))
+		http.Handle(inventoryPath, scpCollector.InventoryHandler())
+		if *enableDebug {
+			http.Handle(debugCollectPath, scpCollector.DebugCollectHandler())
+		}
+	}
+	http.Handle(probePath, probeHandler(&targets))
 	http.Handle("/", landingPage)
 
 	flags := web.FlagConfig{
-		WebListenAddresses: &[]string{*addr},
+		WebListenAddresses: addr,
 		WebSystemdSocket:   new(bool),
 		WebConfigFile:      tlsConfig,
 	}
@@ -89,3 +321,387 @@ func main() {
 	}
 
 }
+
+// parseIncludedTraffic converts the sizes passed to --collector.traffic.included into Bytes
+func parseIncludedTraffic(included map[string]string) (map[string]int64, error) {
+	parsed := make(map[string]int64, len(included))
+	for vserver, size := range included {
+		bytes, err := units.ParseStrictBytes(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid included traffic %q for %s: %w", size, vserver, err)
+		}
+		parsed[vserver] = bytes
+	}
+	return parsed, nil
+}
+
+// collectorOptions translates the collector flags into options for metrics.NewScpCollector
+func collectorOptions(trafficIncluded map[string]int64, labels prometheus.Labels, dropped map[string][]string) []metrics.Option {
+	opts := []metrics.Option{
+		metrics.WithTrafficHistory(*trafficHistory),
+		metrics.WithTrafficGrace(*trafficGrace),
+		metrics.WithIncludedTraffic(trafficIncluded),
+		metrics.WithServerFilter(metrics.ServerFilter{Include: *includeServers, Exclude: *excludeServers}),
+		metrics.WithVServerLabel(*vserverLabel),
+		metrics.WithConstLabels(labels),
+		metrics.WithDroppedLabels(dropped),
+		metrics.WithServerListTTL(*serverListTTL),
+		metrics.WithMinCollectInterval(*collectMinInterval),
+	}
+	if *compatServerStatus {
+		opts = append(opts, metrics.WithCompatServerStatus())
+	}
+	if *compatInterfaces {
+		opts = append(opts, metrics.WithCompatInterfaces())
+	}
+	if *compatV0 {
+		opts = append(opts, metrics.WithCompatV0Metrics())
+	}
+	if *plainTraffic {
+		opts = append(opts, metrics.WithPlainTrafficLabels())
+	}
+	if *dailyTraffic {
+		opts = append(opts, metrics.WithDailyTraffic())
+	}
+	if *logStateChanges {
+		opts = append(opts, metrics.WithStateChangeLogs())
+	}
+	if *nativeHistograms {
+		opts = append(opts, metrics.WithNativeHistograms())
+	}
+	return opts
+}
+
+// refreshServersHandler drops the cached listing of the vservers before serving requests with the refresh_servers parameter
+func refreshServersHandler(scpCollector *metrics.ScpCollector, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("refresh_servers") {
+			scpCollector.RefreshServerList()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newAPIClient returns the client of the webservice at url, a call is aborted after timeout
+func newAPIClient(url string, timeout time.Duration, ipProtocol string, rateLimits *metrics.RateLimits, logger *slog.Logger) metrics.Client {
+	transport := rateLimitTransport{requestIDTransport{keepAliveTransport{apiTransport(ipProtocol, logger)}}, rateLimits}
+	return scpclient.NewWSEndUser(soap.NewClient(url, soap.WithHTTPClient(&http.Client{Timeout: timeout, Transport: transport})))
+}
+
+// rateLimitTransport records the rate limit headers of the webservice responses
+type rateLimitTransport struct {
+	http.RoundTripper
+	rateLimits *metrics.RateLimits
+}
+
+// RoundTrip implements http.RoundTripper
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err == nil {
+		t.rateLimits.Observe(resp.Header, time.Now())
+	}
+	return resp, err
+}
+
+// apiTransport returns the transport for the webservice, connecting only via ipProtocol unless it is any.
+// New connections are logged with their address family, as broken IPv6 routes otherwise only show as slow scrapes.
+func apiTransport(ipProtocol string, logger *slog.Logger) http.RoundTripper {
+	network := map[string]string{"any": "tcp", "ipv4": "tcp4", "ipv6": "tcp6"}[ipProtocol]
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, _ string, address string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		family := "ipv6"
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && addr.IP.To4() != nil {
+			family = "ipv4"
+		}
+		logger.Info("Connected to the SCP webservice", "address", conn.RemoteAddr().String(), "family", family)
+		return conn, nil
+	}
+	return transport
+}
+
+// requestIDTransport sends the request ID the collector assigned to a webservice call, so it can be referenced towards netcup
+type requestIDTransport struct {
+	http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if id := metrics.RequestID(req.Context()); id != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(metrics.RequestIDHeader, id)
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// keepAliveTransport reuses connections to the webservice, the soap client closes them after every request otherwise
+type keepAliveTransport struct {
+	http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t keepAliveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Close = false
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// runCheck performs the --check mode and returns the exit code
+func runCheck(scpCollector *metrics.ScpCollector) int {
+	servers, latency, err := scpCollector.Check(context.Background())
+	switch {
+	case metrics.IsAuthError(err):
+		fmt.Printf("FAILED: the webservice rejected the request, check the credentials: %s\n", err)
+		return 2
+	case err != nil:
+		fmt.Printf("FAILED: unable to reach the webservice: %s\n", err)
+		return 1
+	}
+	fmt.Printf("OK: %d vservers found, API latency %s\n", servers, latency.Round(time.Millisecond))
+	return 0
+}
+
+// runOnce collects the metrics a single time and writes them atomically to path
+func runOnce(scpCollector *metrics.ScpCollector, path string) error {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(scpCollector); err != nil {
+		return err
+	}
+	if err := prometheus.WriteToTextfile(path, registry); err != nil {
+		return err
+	}
+	if failed := scpCollector.FailedRequests(); failed > 0 {
+		return fmt.Errorf("%d API calls failed, metrics are incomplete", failed)
+	}
+	return nil
+}
+
+// metricsRemoteWriteClient returns the client for the --remote-write.* flags
+func metricsRemoteWriteClient() (*remotewrite.Client, error) {
+	httpConfig := config.DefaultHTTPClientConfig
+	if *remoteWriteUsername != "" || *remoteWritePasswordFile != "" {
+		httpConfig.BasicAuth = &config.BasicAuth{Username: *remoteWriteUsername, PasswordFile: *remoteWritePasswordFile}
+	}
+	if *remoteWriteBearerTokenFile != "" {
+		httpConfig.Authorization = &config.Authorization{Type: "Bearer", CredentialsFile: *remoteWriteBearerTokenFile}
+	}
+	return remotewrite.NewClient(*remoteWriteURL, httpConfig)
+}
+
+// reloadCredentials reads the credentials and --config.file again and applies them to the collector and the probe
+// targets, nothing is applied if one of them is invalid. probeOnly is whether the exporter started without credentials
+// for the default collector, the credentials can't be added or removed by a reload.
+func reloadCredentials(logger *slog.Logger, probeOnly bool, scpCollector *metrics.ScpCollector, accounts *atomic.Pointer[probeConfig], targets *atomic.Pointer[probeTargets], newCollector collectorFactory) error {
+	scpLoginName, scpPassword, err := loadCredentials(logger)
+	if err != nil {
+		return err
+	}
+	reloaded := flagConfig(serveCommand.FullCommand(), scpLoginName, scpPassword)
+	if err := validateConfig(reloaded); err != nil {
+		return err
+	}
+	if reloaded.probeOnly() != probeOnly {
+		return errors.New("credentials for " + *metricsPath + " can't be added or removed by a reload, restart the exporter")
+	}
+	probes := &probeConfig{}
+	if *configFile != "" {
+		probes, err = loadProbeConfig(*configFile)
+		if err != nil {
+			return err
+		}
+	}
+	reloadedTargets, err := newProbeTargets(probes, *targets.Load(), newCollector)
+	if err != nil {
+		return err
+	}
+	scpCollector.SetCredentials(scpLoginName, scpPassword)
+	accounts.Store(probes)
+	targets.Store(&reloadedTargets)
+	logger.Info("Reloaded credentials", "login_name", scpLoginName, "password", "<redacted>", "accounts", len(probes.Accounts))
+	return nil
+}
+
+// loadCredentials returns the login name and password from the flags or, if set, the credential files
+func loadCredentials(logger *slog.Logger) (string, string, error) {
+	scpLoginName, err := readCredentialFile(logger, "login-name", *loginName, *loginNameFile)
+	if err != nil {
+		return "", "", err
+	}
+	scpPassword, err := readCredentialFile(logger, "password", *password, *passwordFile)
+	if err != nil {
+		return "", "", err
+	}
+	return scpLoginName, scpPassword, nil
+}
+
+// readCredentialFile returns the contents of path if set, otherwise the value of the --<name> flag
+func readCredentialFile(logger *slog.Logger, name string, value string, path string) (string, error) {
+	if path == "" {
+		return value, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read --%s-file: %w", name, err)
+	}
+	if value != "" {
+		logger.Warn("Both --" + name + " and --" + name + "-file are set, using the file")
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// parseConstLabels validates the label names passed to --metrics.const-label
+func parseConstLabels(labels map[string]string) (prometheus.Labels, error) {
+	parsed := make(prometheus.Labels, len(labels))
+	for name, value := range labels {
+		if !model.LabelName(name).IsValidLegacy() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return nil, fmt.Errorf("invalid label name %q", name)
+		}
+		parsed[name] = value
+	}
+	return parsed, nil
+}
+
+// parseDroppedLabels groups the <metric>:<label> pairs passed to --metrics.drop-label by metric
+func parseDroppedLabels(values []string) (map[string][]string, error) {
+	dropped := make(map[string][]string)
+	for _, value := range values {
+		metric, label, ok := strings.Cut(value, ":")
+		if !ok || metric == "" || label == "" {
+			return nil, fmt.Errorf("%q is not of the form <metric>:<label>", value)
+		}
+		dropped[metric] = append(dropped[metric], label)
+	}
+	return dropped, nil
+}
+
+// startupConfig holds the flag values and the resolved credentials checked by validateConfig
+type startupConfig struct {
+	loginName           string
+	password            string
+	mock                bool
+	fixturesDir         string
+	recordDir           string
+	configFile          string
+	plainTraffic        bool
+	trafficHistory      int
+	trafficGrace        time.Duration
+	once                bool
+	check               bool
+	listServers         bool
+	outputFile          string
+	remoteWriteURL      string
+	remoteWriteInterval time.Duration
+	metricsPath         string
+	apiURL              string
+	apiTimeout          time.Duration
+	constLabels         map[string]string
+}
+
+// flagConfig returns the startupConfig of the parsed flags, the selected command and the resolved credentials
+func flagConfig(command string, scpLoginName string, scpPassword string) startupConfig {
+	return startupConfig{
+		loginName:           scpLoginName,
+		password:            scpPassword,
+		mock:                *mock,
+		fixturesDir:         *fixturesDir,
+		recordDir:           *recordDir,
+		configFile:          *configFile,
+		plainTraffic:        *plainTraffic,
+		trafficHistory:      *trafficHistory,
+		trafficGrace:        *trafficGrace,
+		once:                *once,
+		check:               *check,
+		listServers:         command == listServersCommand.FullCommand(),
+		outputFile:          *outputFile,
+		remoteWriteURL:      *remoteWriteURL,
+		remoteWriteInterval: *remoteWriteInterval,
+		metricsPath:         *metricsPath,
+		apiURL:              *apiURL,
+		apiTimeout:          *apiTimeout,
+		constLabels:         *constLabels,
+	}
+}
+
+// probeOnly reports whether only the accounts of the config file are collected, as no credentials are set for the
+// default collector
+func (c startupConfig) probeOnly() bool {
+	return c.configFile != "" && c.loginName == "" && c.password == "" && !c.mock && c.fixturesDir == ""
+}
+
+// validateConfig checks the configuration for errors that would only surface at scrape time
+func validateConfig(c startupConfig) error {
+	if c.mock && c.fixturesDir != "" {
+		return errors.New("--mock can't be combined with --api.fixtures-dir")
+	}
+	if c.recordDir != "" && (c.mock || c.fixturesDir != "") {
+		return errors.New("--api.record-dir can't be combined with --mock or --api.fixtures-dir")
+	}
+	if c.probeOnly() && (c.once || c.check || c.listServers || c.remoteWriteURL != "") {
+		return errors.New("--once, --check, list-servers and --remote-write.url use the default collector, set its credentials with --login-name and --password, the accounts of --config.file are only collected via " + probePath)
+	}
+	// With a config file, credentials may only be configured for /probe, fixtures don't need any
+	needsCredentials := !c.mock && c.fixturesDir == "" && !c.probeOnly()
+	if needsCredentials && c.loginName == "" {
+		return errors.New("login name is empty, set --login-name / SCP_LOGINNAME or --login-name-file / SCP_LOGINNAME_FILE")
+	}
+	if needsCredentials && c.password == "" {
+		return errors.New("password is empty, set --password / SCP_PASSWORD or --password-file / SCP_PASSWORD_FILE")
+	}
+	if c.plainTraffic && (c.trafficHistory > 0 || c.trafficGrace > 0) {
+		return errors.New("--collector.traffic.plain-labels can't be combined with --collector.traffic.history-months or --collector.traffic.previous-month-grace")
+	}
+	if c.once && c.outputFile == "" {
+		return errors.New("--once requires --output.file")
+	}
+	if c.remoteWriteURL != "" {
+		if err := validateAPIURL(c.remoteWriteURL); err != nil {
+			return fmt.Errorf("invalid --remote-write.url: %w", err)
+		}
+		if c.remoteWriteInterval <= 0 {
+			return errors.New("--remote-write.interval must be positive")
+		}
+	}
+	if err := validateTelemetryPath(c.metricsPath); err != nil {
+		return fmt.Errorf("invalid --web.telemetry-path: %w", err)
+	}
+	if err := validateAPIURL(c.apiURL); err != nil {
+		return fmt.Errorf("invalid --api.url: %w", err)
+	}
+	if c.apiTimeout <= 0 {
+		return errors.New("--api.timeout must be positive")
+	}
+	if _, ok := c.constLabels[metrics.AccountLabel]; ok && c.configFile != "" {
+		return fmt.Errorf("--metrics.const-label can't set %q with --config.file, probes add it themselves", metrics.AccountLabel)
+	}
+	return nil
+}
+
+// validateAPIURL checks that the URL passed to --api.url is an absolute http(s) URL
+func validateAPIURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%q is not an absolute http(s) URL", rawURL)
+	}
+	return nil
+}
+
+// validateTelemetryPath checks that the path passed to --web.telemetry-path doesn't clash with the other handlers
+func validateTelemetryPath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("%q must start with /", path)
+	}
+	if path == "/" {
+		return fmt.Errorf("%q conflicts with the landing page", path)
+	}
+	if path == inventoryPath || path == probePath || path == debugCollectPath {
+		return fmt.Errorf("%q conflicts with the %s endpoint", path, path)
+	}
+	return nil
+}
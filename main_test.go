@@ -0,0 +1,264 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/mrueg/netcupscp-exporter/pkg/metrics"
+	"github.com/mrueg/netcupscp-exporter/pkg/scpclient"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// validConfig returns a startupConfig that passes validateConfig, like the defaults with credentials set
+func validConfig() startupConfig {
+	return startupConfig{
+		loginName:           "123456",
+		password:            "secret",
+		remoteWriteInterval: time.Minute,
+		metricsPath:         "/metrics",
+		apiURL:              netcupWSUrl,
+		apiTimeout:          10 * time.Second,
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *startupConfig)
+		err    string
+	}{
+		{"defaults", func(c *startupConfig) {}, ""},
+		{"mock with fixtures dir", func(c *startupConfig) { c.mock, c.fixturesDir = true, "fixtures" }, "--mock can't be combined with --api.fixtures-dir"},
+		{"record dir with mock", func(c *startupConfig) { c.recordDir, c.mock = "recordings", true }, "--api.record-dir can't be combined"},
+		{"record dir with fixtures dir", func(c *startupConfig) { c.recordDir, c.fixturesDir = "recordings", "fixtures" }, "--api.record-dir can't be combined"},
+		{"empty login name", func(c *startupConfig) { c.loginName = "" }, "login name is empty"},
+		{"empty password", func(c *startupConfig) { c.password = "" }, "password is empty"},
+		{"no credentials with mock", func(c *startupConfig) { c.loginName, c.password, c.mock = "", "", true }, ""},
+		{"no credentials with fixtures dir", func(c *startupConfig) { c.loginName, c.password, c.fixturesDir = "", "", "fixtures" }, ""},
+		{"no credentials with config file", func(c *startupConfig) { c.loginName, c.password, c.configFile = "", "", "config.yml" }, ""},
+		{"password without login name with config file", func(c *startupConfig) { c.loginName, c.configFile = "", "config.yml" }, "login name is empty"},
+		{"login name without password with config file", func(c *startupConfig) { c.password, c.configFile = "", "config.yml" }, "password is empty"},
+		{"once without credentials with config file", func(c *startupConfig) {
+			c.loginName, c.password, c.configFile, c.once, c.outputFile = "", "", "config.yml", true, "scp.prom"
+		}, "use the default collector"},
+		{"check without credentials with config file", func(c *startupConfig) { c.loginName, c.password, c.configFile, c.check = "", "", "config.yml", true }, "use the default collector"},
+		{"list-servers without credentials with config file", func(c *startupConfig) {
+			c.loginName, c.password, c.configFile, c.listServers = "", "", "config.yml", true
+		}, "use the default collector"},
+		{"remote write without credentials with config file", func(c *startupConfig) {
+			c.loginName, c.password, c.configFile, c.remoteWriteURL = "", "", "config.yml", "http://localhost/api/v1/write"
+		}, "use the default collector"},
+		{"once without credentials with mock", func(c *startupConfig) {
+			c.loginName, c.password, c.mock, c.once, c.outputFile = "", "", true, true, "scp.prom"
+		}, ""},
+		{"plain traffic with history", func(c *startupConfig) { c.plainTraffic, c.trafficHistory = true, 1 }, "--collector.traffic.plain-labels can't be combined"},
+		{"plain traffic with grace", func(c *startupConfig) { c.plainTraffic, c.trafficGrace = true, time.Hour }, "--collector.traffic.plain-labels can't be combined"},
+		{"once without output file", func(c *startupConfig) { c.once = true }, "--once requires --output.file"},
+		{"once with output file", func(c *startupConfig) { c.once, c.outputFile = true, "scp.prom" }, ""},
+		{"relative remote write URL", func(c *startupConfig) { c.remoteWriteURL = "/api/v1/write" }, "invalid --remote-write.url"},
+		{"zero remote write interval", func(c *startupConfig) { c.remoteWriteURL, c.remoteWriteInterval = "http://localhost/api/v1/write", 0 }, "--remote-write.interval must be positive"},
+		{"relative telemetry path", func(c *startupConfig) { c.metricsPath = "metrics" }, "invalid --web.telemetry-path"},
+		{"telemetry path on landing page", func(c *startupConfig) { c.metricsPath = "/" }, "conflicts with the landing page"},
+		{"telemetry path on probe endpoint", func(c *startupConfig) { c.metricsPath = probePath }, "conflicts with the /probe endpoint"},
+		{"telemetry path on inventory endpoint", func(c *startupConfig) { c.metricsPath = inventoryPath }, "conflicts with the " + inventoryPath + " endpoint"},
+		{"telemetry path on debug endpoint", func(c *startupConfig) { c.metricsPath = debugCollectPath }, "conflicts with the " + debugCollectPath + " endpoint"},
+		{"API URL without scheme", func(c *startupConfig) { c.apiURL = "www.servercontrolpanel.de/SCP/WSEndUser" }, "invalid --api.url"},
+		{"API URL with other scheme", func(c *startupConfig) { c.apiURL = "ftp://www.servercontrolpanel.de/" }, "invalid --api.url"},
+		{"zero API timeout", func(c *startupConfig) { c.apiTimeout = 0 }, "--api.timeout must be positive"},
+		{"account const label with config file", func(c *startupConfig) {
+			c.configFile, c.constLabels = "config.yml", map[string]string{"account": "customer1"}
+		}, `can't set "account" with --config.file`},
+		{"account const label without config file", func(c *startupConfig) { c.constLabels = map[string]string{"account": "customer1"} }, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := validConfig()
+			test.modify(&config)
+			err := validateConfig(config)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case test.err != "" && err == nil:
+				t.Errorf("expected an error containing %q", test.err)
+			case test.err != "" && !strings.Contains(err.Error(), test.err):
+				t.Errorf("expected an error containing %q, got %q", test.err, err)
+			}
+		})
+	}
+}
+
+// parseFlags parses args like the command line, flags missing from args get their defaults
+func parseFlags(t *testing.T, args ...string) {
+	t.Helper()
+	if _, err := kingpin.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := kingpin.CommandLine.Parse(nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// writeFile writes content to name in a temporary directory and returns its path
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReloadCredentialsDoesNotLogPasswords(t *testing.T) {
+	const flagPassword, filePassword, probePassword = "flag-s3cr3t", "file-s3cr3t", "probe-s3cr3t"
+	tests := []struct {
+		name   string
+		config string
+		err    bool
+	}{
+		{"reloaded", "accounts:\n  customer1:\n    login_name: \"654321\"\n    password: " + probePassword + "\n", false},
+		{"account without login name", "accounts:\n  customer1:\n    password: " + probePassword + "\n", true},
+		{"unknown field", "accounts:\n  customer1:\n    login_name: \"654321\"\n    passwort: " + probePassword + "\n", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parseFlags(t, "--login-name=123456", "--password="+flagPassword, "--password-file="+writeFile(t, "password", filePassword+"\n"),
+				"--config.file="+writeFile(t, "config.yml", test.config))
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			scpCollector, err := metrics.NewScpCollector(nil, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			newCollector := func(loginName string, password string, extra ...metrics.Option) (*metrics.ScpCollector, error) {
+				return metrics.NewScpCollector(nil, logger, append([]metrics.Option{metrics.WithCredentials(loginName, password)}, extra...)...)
+			}
+			var accounts atomic.Pointer[probeConfig]
+			accounts.Store(&probeConfig{})
+			var targets atomic.Pointer[probeTargets]
+			targets.Store(&probeTargets{})
+			err = reloadCredentials(logger, false, scpCollector, &accounts, &targets, newCollector)
+			if err != nil {
+				// Logged like by main
+				logger.Error("failed to reload credentials", "error", err.Error())
+			}
+			if (err != nil) != test.err {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if logs.Len() == 0 {
+				t.Fatal("expected log messages")
+			}
+			for _, secret := range []string{flagPassword, filePassword, probePassword} {
+				if strings.Contains(logs.String(), secret) {
+					t.Errorf("password %q was logged:\n%s", secret, logs.String())
+				}
+			}
+		})
+	}
+}
+
+func TestAPIClientTimeout(t *testing.T) {
+	parseFlags(t, "--api.timeout=100ms")
+	released := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-released:
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(released)
+	client := newAPIClient(server.URL, *apiTimeout, *apiIPProtocol, metrics.NewRateLimits(nil), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	start := time.Now()
+	_, err := client.GetVServersContext(context.Background(), &scpclient.GetVServers{LoginName: "123456", Password: "secret"})
+	elapsed := time.Since(start)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if elapsed < *apiTimeout || elapsed > 5*time.Second {
+		t.Errorf("expected the call to be aborted after %s, took %s", *apiTimeout, elapsed)
+	}
+}
+
+func TestReloadCredentialsKeepsProbeOnly(t *testing.T) {
+	config := writeFile(t, "config.yml", "accounts:\n  customer1:\n    login_name: \"654321\"\n    password: secret\n")
+	tests := []struct {
+		name      string
+		args      []string
+		probeOnly bool
+		err       bool
+	}{
+		{"probe only", []string{"--config.file=" + config}, true, false},
+		{"credentials added", []string{"--config.file=" + config, "--login-name=123456", "--password=secret"}, true, true},
+		{"credentials removed", []string{"--config.file=" + config}, false, true},
+		{"credentials kept", []string{"--config.file=" + config, "--login-name=123456", "--password=secret"}, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parseFlags(t, test.args...)
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			scpCollector, err := metrics.NewScpCollector(nil, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			newCollector := func(loginName string, password string, extra ...metrics.Option) (*metrics.ScpCollector, error) {
+				return metrics.NewScpCollector(nil, logger, append([]metrics.Option{metrics.WithCredentials(loginName, password)}, extra...)...)
+			}
+			var accounts atomic.Pointer[probeConfig]
+			accounts.Store(&probeConfig{})
+			var targets atomic.Pointer[probeTargets]
+			targets.Store(&probeTargets{})
+			err = reloadCredentials(logger, test.probeOnly, scpCollector, &accounts, &targets, newCollector)
+			if (err != nil) != test.err {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if reloaded := len(accounts.Load().Accounts) == 1; reloaded == test.err {
+				t.Errorf("expected the accounts to be reloaded only without an error, got %v", accounts.Load().Accounts)
+			}
+		})
+	}
+}
+
+func TestRequestIDTransport(t *testing.T) {
+	var sent atomic.Pointer[string]
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(metrics.RequestIDHeader)
+		sent.Store(&id)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	client := newAPIClient(server.URL, time.Second, "any", metrics.NewRateLimits(nil), logger)
+	collector, err := metrics.NewScpCollector(client, logger, metrics.WithCredentials("123456", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CollectAndCount(collector)
+	id := sent.Load()
+	if id == nil || *id == "" {
+		t.Fatalf("expected the request ID to be sent in %s", metrics.RequestIDHeader)
+	}
+	if !strings.Contains(logs.String(), "request_id="+*id) {
+		t.Errorf("expected the failed call to be logged with request_id=%s, got:\n%s", *id, logs.String())
+	}
+}
@@ -0,0 +1,131 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package fixtures implements the SCP webservice client with responses read from JSON files
+package fixtures
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+
+	"github.com/mrueg/netcupscp-exporter/pkg/scpclient"
+)
+
+//go:embed demo
+var demo embed.FS
+
+// Demo returns the bundled fixtures of an account with three vservers
+func Demo() fs.FS {
+	fsys, err := fs.Sub(demo, "demo")
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
+
+// Path returns the path of the fixture for a call to method, e.g. getVServerInformation/v2200000000000000000.json
+func Path(method string, name string) string {
+	if name == "" {
+		return method + ".json"
+	}
+	return path.Join(method, name+".json")
+}
+
+// monthName is the name of the monthly traffic fixture of a vserver
+func monthName(vserver string, year int32, month int32) string {
+	return fmt.Sprintf("%s/%d-%02d", vserver, year, month)
+}
+
+// dayName is the name of the daily traffic fixture of a vserver
+func dayName(vserver string, year int32, month int32, day int32) string {
+	return fmt.Sprintf("%s/%d-%02d-%02d", vserver, year, month, day)
+}
+
+// Client answers the calls of the metrics collector from the fixtures in fsys, calls without a fixture fail
+type Client struct {
+	fsys fs.FS
+}
+
+// NewClient returns a client reading the fixtures from fsys
+func NewClient(fsys fs.FS) *Client {
+	return &Client{fsys: fsys}
+}
+
+// read decodes the first existing fixture of names into response
+func (c *Client) read(response any, names ...string) error {
+	var content []byte
+	var err error
+	var name string
+	for _, name = range names {
+		content, err = fs.ReadFile(c.fsys, name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("no fixture: %w", err)
+	}
+	if err := json.Unmarshal(content, response); err != nil {
+		return fmt.Errorf("invalid fixture %s: %w", name, err)
+	}
+	return nil
+}
+
+// GetVServersContext implements metrics.Client
+func (c *Client) GetVServersContext(_ context.Context, request *scpclient.GetVServers) (*scpclient.GetVServersResponse, error) {
+	response := &scpclient.GetVServersResponse{}
+	if err := c.read(response, Path("getVServers", "")); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// GetVServerInformationContext implements metrics.Client
+func (c *Client) GetVServerInformationContext(_ context.Context, request *scpclient.GetVServerInformation) (*scpclient.GetVServerInformationResponse, error) {
+	response := &scpclient.GetVServerInformationResponse{}
+	if err := c.read(response, Path("getVServerInformation", request.Vservername)); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// GetUserDataContext implements metrics.Client
+func (c *Client) GetUserDataContext(_ context.Context, request *scpclient.GetUserData) (*scpclient.GetUserDataResponse, error) {
+	response := &scpclient.GetUserDataResponse{}
+	if err := c.read(response, Path("getUserData", "")); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// GetVServerTrafficOfMonthContext implements metrics.Client, getVServerTrafficOfMonth/<name>.json answers months without a fixture of their own
+func (c *Client) GetVServerTrafficOfMonthContext(_ context.Context, request *scpclient.GetVServerTrafficOfMonth) (*scpclient.GetVServerTrafficOfMonthResponse, error) {
+	response := &scpclient.GetVServerTrafficOfMonthResponse{}
+	month := monthName(request.VserverName, request.Year, request.Month)
+	if err := c.read(response, Path("getVServerTrafficOfMonth", month), Path("getVServerTrafficOfMonth", request.VserverName)); err != nil {
+		return nil, err
+	}
+	if response.Return_ != nil {
+		response.Return_.Year, response.Return_.Month = request.Year, request.Month
+	}
+	return response, nil
+}
+
+// GetVServerTrafficOfDayContext implements metrics.Client, getVServerTrafficOfDay/<name>.json answers days without a fixture of their own
+func (c *Client) GetVServerTrafficOfDayContext(_ context.Context, request *scpclient.GetVServerTrafficOfDay) (*scpclient.GetVServerTrafficOfDayResponse, error) {
+	response := &scpclient.GetVServerTrafficOfDayResponse{}
+	day := dayName(request.VserverName, request.Year, request.Month, request.Day)
+	if err := c.read(response, Path("getVServerTrafficOfDay", day), Path("getVServerTrafficOfDay", request.VserverName)); err != nil {
+		return nil, err
+	}
+	if response.Return_ != nil && response.Return_.TrafficMonthObject != nil {
+		response.Return_.Year, response.Return_.Month, response.Return_.Day = request.Year, request.Month, request.Day
+	}
+	return response, nil
+}
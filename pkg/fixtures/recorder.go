@@ -0,0 +1,129 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fixtures
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/mrueg/netcupscp-exporter/pkg/metrics"
+	"github.com/mrueg/netcupscp-exporter/pkg/scpclient"
+)
+
+// redacted replaces the login name and password in recorded responses
+const redacted = "REDACTED"
+
+// Recorder passes the calls of the metrics collector to a client and saves the responses as fixtures in dir.
+// The saved fixtures can be replayed with NewClient(os.DirFS(dir)).
+type Recorder struct {
+	client metrics.Client
+	dir    string
+	logger *slog.Logger
+}
+
+// NewRecorder returns a recorder saving the responses of client to dir, failing to save a response is logged but doesn't fail the call
+func NewRecorder(client metrics.Client, dir string, logger *slog.Logger) *Recorder {
+	return &Recorder{client: client, dir: dir, logger: logger}
+}
+
+// save writes the response as fixture name with the credentials and the XML metadata of the scpclient types removed
+func (r *Recorder) save(name string, response any, secrets ...string) {
+	content, err := json.Marshal(response)
+	if err != nil {
+		r.logger.Warn("Unable to record response", "fixture", name, "error", err.Error())
+		return
+	}
+	var decoded any
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		r.logger.Warn("Unable to record response", "fixture", name, "error", err.Error())
+		return
+	}
+	content, err = json.MarshalIndent(scrub(decoded, secrets), "", "  ")
+	if err != nil {
+		r.logger.Warn("Unable to record response", "fixture", name, "error", err.Error())
+		return
+	}
+	file := filepath.Join(r.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		r.logger.Warn("Unable to record response", "fixture", name, "error", err.Error())
+		return
+	}
+	if err := os.WriteFile(file, append(content, '\n'), 0o644); err != nil {
+		r.logger.Warn("Unable to record response", "fixture", name, "error", err.Error())
+	}
+}
+
+// scrub removes the XMLName fields from a decoded JSON value and replaces the strings equal to one of secrets
+func scrub(value any, secrets []string) any {
+	switch value := value.(type) {
+	case map[string]any:
+		delete(value, "XMLName")
+		for key, field := range value {
+			value[key] = scrub(field, secrets)
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = scrub(item, secrets)
+		}
+	case string:
+		if value != "" && slices.Contains(secrets, value) {
+			return redacted
+		}
+	}
+	return value
+}
+
+// GetVServersContext implements metrics.Client
+func (r *Recorder) GetVServersContext(ctx context.Context, request *scpclient.GetVServers) (*scpclient.GetVServersResponse, error) {
+	response, err := r.client.GetVServersContext(ctx, request)
+	if err == nil {
+		r.save(Path("getVServers", ""), response, request.LoginName, request.Password)
+	}
+	return response, err
+}
+
+// GetVServerInformationContext implements metrics.Client
+func (r *Recorder) GetVServerInformationContext(ctx context.Context, request *scpclient.GetVServerInformation) (*scpclient.GetVServerInformationResponse, error) {
+	response, err := r.client.GetVServerInformationContext(ctx, request)
+	if err == nil {
+		r.save(Path("getVServerInformation", request.Vservername), response, request.LoginName, request.Password)
+	}
+	return response, err
+}
+
+// GetVServerTrafficOfMonthContext implements metrics.Client
+func (r *Recorder) GetVServerTrafficOfMonthContext(ctx context.Context, request *scpclient.GetVServerTrafficOfMonth) (*scpclient.GetVServerTrafficOfMonthResponse, error) {
+	response, err := r.client.GetVServerTrafficOfMonthContext(ctx, request)
+	if err == nil {
+		r.save(Path("getVServerTrafficOfMonth", monthName(request.VserverName, request.Year, request.Month)), response, request.LoginName, request.Password)
+	}
+	return response, err
+}
+
+// GetVServerTrafficOfDayContext implements metrics.Client
+func (r *Recorder) GetVServerTrafficOfDayContext(ctx context.Context, request *scpclient.GetVServerTrafficOfDay) (*scpclient.GetVServerTrafficOfDayResponse, error) {
+	response, err := r.client.GetVServerTrafficOfDayContext(ctx, request)
+	if err == nil {
+		r.save(Path("getVServerTrafficOfDay", dayName(request.VserverName, request.Year, request.Month, request.Day)), response, request.LoginName, request.Password)
+	}
+	return response, err
+}
+
+// GetUserDataContext implements metrics.Client, only the login name is saved as the response contains the address of the customer
+func (r *Recorder) GetUserDataContext(ctx context.Context, request *scpclient.GetUserData) (*scpclient.GetUserDataResponse, error) {
+	response, err := r.client.GetUserDataContext(ctx, request)
+	if err == nil {
+		saved := &scpclient.GetUserDataResponse{}
+		if response.Return_ != nil {
+			saved.Return_ = &scpclient.UserDataObject{Loginname: response.Return_.Loginname}
+		}
+		r.save(Path("getUserData", ""), saved, request.LoginName, request.Password)
+	}
+	return response, err
+}
@@ -0,0 +1,102 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package fixtures
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mrueg/netcupscp-exporter/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRecorderDoesNotSavePassword(t *testing.T) {
+	const loginName, password = "123456", "s3cr3t-password"
+	// The webservice doesn't echo the password, the fixtures do to check that it's redacted anyway. The collector logs the
+	// responses at debug level, only the messages of the recorder are checked.
+	replayed := fstest.MapFS{
+		"getVServers.json":                 {Data: []byte(`{"return": ["v1"]}`)},
+		"getUserData.json":                 {Data: []byte(`{"return": {"loginname": "` + loginName + `"}}`)},
+		"getVServerInformation/v1.json":    {Data: []byte(`{"return": {"vServerName": "v1", "vServerNickname": "` + password + `", "status": "online"}}`)},
+		"getVServerTrafficOfMonth/v1.json": {Data: []byte(`{}`)},
+		"getVServerTrafficOfDay/v1.json":   {Data: []byte(`{"return": {}}`)},
+	}
+	tests := []struct {
+		name   string
+		dir    func(t *testing.T) string
+		saved  bool
+		logged string
+	}{
+		{"recorded", func(t *testing.T) string { return t.TempDir() }, true, ""},
+		{"directory can't be created", func(t *testing.T) string {
+			file := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(file, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			return filepath.Join(file, "fixtures")
+		}, false, "Unable to record response"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := test.dir(t)
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			recorder := NewRecorder(NewClient(replayed), dir, logger)
+			collector, err := metrics.NewScpCollector(recorder, slog.New(slog.NewTextHandler(io.Discard, nil)), metrics.WithCredentials(loginName, password))
+			if err != nil {
+				t.Fatal(err)
+			}
+			registry := prometheus.NewPedanticRegistry()
+			registry.MustRegister(collector)
+			if _, err := registry.Gather(); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(logs.String(), test.logged) {
+				t.Errorf("expected %q to be logged, got:\n%s", test.logged, logs.String())
+			}
+			if strings.Contains(logs.String(), password) {
+				t.Errorf("password was logged:\n%s", logs.String())
+			}
+			if !test.saved {
+				return
+			}
+			saved := 0
+			err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+				if err != nil || entry.IsDir() {
+					return err
+				}
+				content, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				saved++
+				if strings.Contains(string(content), password) || strings.Contains(string(content), loginName) {
+					t.Errorf("credentials were saved in %s:\n%s", path, content)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved == 0 {
+				t.Fatal("expected recorded fixtures")
+			}
+			content, err := os.ReadFile(filepath.Join(dir, "getVServerInformation", "v1.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(content), redacted) {
+				t.Errorf("expected the password to be redacted:\n%s", content)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mrueg/netcupscp-exporter/pkg/scpclient"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fakeClient answers the calls of the collector with fixed responses and errors, keyed by vserver where the call is
+// about a single one. It must not be modified once the collector uses it, so concurrent collections can share it.
+type fakeClient struct {
+	servers     *scpclient.GetVServersResponse
+	serversErr  error
+	info        map[string]*scpclient.GetVServerInformationResponse
+	infoErr     map[string]error
+	month       map[string]*scpclient.GetVServerTrafficOfMonthResponse
+	monthErr    map[string]error
+	day         map[string]*scpclient.GetVServerTrafficOfDayResponse
+	dayErr      map[string]error
+	userData    *scpclient.GetUserDataResponse
+	userDataErr error
+	calls       atomic.Int64
+	listings    atomic.Int64
+	monthCalls  atomic.Int64
+}
+
+// GetVServersContext implements Client
+func (c *fakeClient) GetVServersContext(ctx context.Context, request *scpclient.GetVServers) (*scpclient.GetVServersResponse, error) {
+	c.calls.Add(1)
+	c.listings.Add(1)
+	if c.serversErr != nil {
+		return nil, c.serversErr
+	}
+	return c.servers, nil
+}
+
+// GetVServerInformationContext implements Client
+func (c *fakeClient) GetVServerInformationContext(ctx context.Context, request *scpclient.GetVServerInformation) (*scpclient.GetVServerInformationResponse, error) {
+	c.calls.Add(1)
+	if err := c.infoErr[request.Vservername]; err != nil {
+		return nil, err
+	}
+	if response, ok := c.info[request.Vservername]; ok {
+		return response, nil
+	}
+	return nil, errors.New("unknown vserver " + request.Vservername)
+}
+
+// GetVServerTrafficOfMonthContext implements Client
+func (c *fakeClient) GetVServerTrafficOfMonthContext(ctx context.Context, request *scpclient.GetVServerTrafficOfMonth) (*scpclient.GetVServerTrafficOfMonthResponse, error) {
+	c.calls.Add(1)
+	c.monthCalls.Add(1)
+	if err := c.monthErr[request.VserverName]; err != nil {
+		return nil, err
+	}
+	if response, ok := c.month[request.VserverName]; ok {
+		return response, nil
+	}
+	return &scpclient.GetVServerTrafficOfMonthResponse{}, nil
+}
+
+// GetVServerTrafficOfDayContext implements Client
+func (c *fakeClient) GetVServerTrafficOfDayContext(ctx context.Context, request *scpclient.GetVServerTrafficOfDay) (*scpclient.GetVServerTrafficOfDayResponse, error) {
+	c.calls.Add(1)
+	if err := c.dayErr[request.VserverName]; err != nil {
+		return nil, err
+	}
+	if response, ok := c.day[request.VserverName]; ok {
+		return response, nil
+	}
+	return &scpclient.GetVServerTrafficOfDayResponse{}, nil
+}
+
+// GetUserDataContext implements Client
+func (c *fakeClient) GetUserDataContext(ctx context.Context, request *scpclient.GetUserData) (*scpclient.GetUserDataResponse, error) {
+	c.calls.Add(1)
+	if c.userDataErr != nil {
+		return nil, c.userDataErr
+	}
+	if c.userData != nil {
+		return c.userData, nil
+	}
+	return &scpclient.GetUserDataResponse{Return_: &scpclient.UserDataObject{Loginname: "123456"}}, nil
+}
+
+// stringPtrs returns pointers to values, like the lists of the scpclient types
+func stringPtrs(values ...string) []*string {
+	pointers := make([]*string, 0, len(values))
+	for _, value := range values {
+		pointers = append(pointers, &value)
+	}
+	return pointers
+}
+
+// testServer returns the information of an online vserver with one interface and one disk
+func testServer(name string, nickname string) *scpclient.GetVServerInformationResponse {
+	return &scpclient.GetVServerInformationResponse{Return_: &scpclient.VServerInformationObject{
+		VServerName:     name,
+		VServerNickname: nickname,
+		Status:          "online",
+		CpuCores:        2,
+		Memory:          2048,
+		Uptime:          "1 day 2 hours 3 minutes",
+		CurrentMonth:    &scpclient.TrafficMonthObject{Year: 2024, Month: 10, In: 1024, Out: 2048, Total: 3072},
+		Ips:             stringPtrs("192.0.2.1", "2001:db8::/64"),
+		ServerInterfaces: []*scpclient.ServerInterface{{
+			Driver: "virtio",
+			Id:     "1",
+			Mac:    "02:00:00:00:00:01",
+			Ipv4IP: stringPtrs("192.0.2.1"),
+			Ipv6IP: stringPtrs("2001:db8::/64"),
+		}},
+		ServerDisks: []*scpclient.ServerDisk{{Name: "vda", Driver: "virtio", Capacity: 80, Used: 20}},
+	}}
+}
+
+// newFakeClient returns a client for an account with the given vservers, named v1, v2 and so on
+func newFakeClient(nicknames ...string) *fakeClient {
+	client := &fakeClient{
+		servers: &scpclient.GetVServersResponse{},
+		info:    make(map[string]*scpclient.GetVServerInformationResponse),
+	}
+	for i, nickname := range nicknames {
+		name := "v" + string(rune('1'+i))
+		client.servers.Return_ = append(client.servers.Return_, &name)
+		client.info[name] = testServer(name, nickname)
+	}
+	return client
+}
+
+// testLogger returns a logger discarding all messages
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// newTestCollector returns a collector for client, failing the test on invalid options
+func newTestCollector(t testing.TB, client Client, opts ...Option) *ScpCollector {
+	t.Helper()
+	collector, err := NewScpCollector(client, testLogger(), append([]Option{WithCredentials("123456", "secret")}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collector
+}
+
+// gather collects the metrics of collector through a pedantic registry, which fails on inconsistent metrics, and returns
+// them keyed by name
+func gather(t testing.TB, collector prometheus.Collector) map[string][]*dto.Metric {
+	t.Helper()
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := make(map[string][]*dto.Metric, len(families))
+	for _, family := range families {
+		gathered[family.GetName()] = family.Metric
+	}
+	return gathered
+}
+
+// labelValues returns the value of label of every metric, sorted
+func labelValues(metrics []*dto.Metric, label string) []string {
+	var values []string
+	for _, metric := range metrics {
+		for _, pair := range metric.Label {
+			if pair.GetName() == label {
+				values = append(values, pair.GetValue())
+			}
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// value returns the value of the only metric in metrics, failing the test if there is none or several
+func value(t testing.TB, name string, metrics []*dto.Metric) float64 {
+	t.Helper()
+	if len(metrics) != 1 {
+		t.Fatalf("expected one series of %s, got %d", name, len(metrics))
+	}
+	switch metric := metrics[0]; {
+	case metric.Gauge != nil:
+		return metric.Gauge.GetValue()
+	case metric.Counter != nil:
+		return metric.Counter.GetValue()
+	}
+	t.Fatalf("%s is neither a gauge nor a counter", name)
+	return 0
+}
+
+// assertVServers fails the test unless name is exported for exactly the given vservers
+func assertVServers(t testing.TB, gathered map[string][]*dto.Metric, name string, vservers ...string) {
+	t.Helper()
+	got := strings.Join(labelValues(gathered[name], "vserver"), ",")
+	if want := strings.Join(vservers, ","); got != want {
+		t.Errorf("expected %s for vservers [%s], got [%s]", name, want, got)
+	}
+}
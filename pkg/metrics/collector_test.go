@@ -0,0 +1,657 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hooklift/gowsdl/soap"
+	"github.com/mrueg/netcupscp-exporter/pkg/scpclient"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestCollect(t *testing.T) {
+	collector := newTestCollector(t, newFakeClient("web-1", "db-1"), WithNicknameLabels())
+	gathered := gather(t, collector)
+	for _, name := range []string{"scp_cpu_cores", "scp_memory_bytes", "scp_server_status", "scp_server_info", "scp_interface_throttled", "scp_disks_count", "scp_server_start_time_seconds"} {
+		assertVServers(t, gathered, name, "db-1", "web-1")
+	}
+	if got := value(t, "scp_servers_total", gathered["scp_servers_total"]); got != 2 {
+		t.Errorf("expected 2 vservers, got %g", got)
+	}
+	if got := value(t, "scp_api_auth_ok", gathered["scp_api_auth_ok"]); got != 1 {
+		t.Errorf("expected scp_api_auth_ok 1, got %g", got)
+	}
+	if got := labelValues(gathered["scp_account_info"], "login"); len(got) != 1 || got[0] != "123456" {
+		t.Errorf("expected the account info of 123456, got %v", got)
+	}
+	if failed := collector.FailedRequests(); failed != 0 {
+		t.Errorf("expected no failed requests, got %d", failed)
+	}
+}
+
+func TestCollectAPIErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(client *fakeClient)
+		opts     []Option
+		vservers []string
+		authOK   []float64
+		failed   int64
+	}{
+		{
+			name:   "listing fails",
+			modify: func(client *fakeClient) { client.serversErr = errors.New("connection refused") },
+			failed: 1,
+		},
+		{
+			name: "credentials rejected",
+			modify: func(client *fakeClient) {
+				client.serversErr = &soap.SOAPFault{Code: "soap:Server", String: "validation error"}
+			},
+			authOK: []float64{0},
+			failed: 1,
+		},
+		{
+			name:   "proxy rejects the request",
+			modify: func(client *fakeClient) { client.serversErr = &soap.HTTPError{StatusCode: http.StatusForbidden} },
+			authOK: []float64{0},
+			failed: 1,
+		},
+		{
+			name: "webservice answers with 500",
+			modify: func(client *fakeClient) {
+				client.serversErr = &soap.HTTPError{StatusCode: http.StatusInternalServerError}
+			},
+			failed: 1,
+		},
+		{
+			name:     "information of a vserver fails",
+			modify:   func(client *fakeClient) { client.infoErr = map[string]error{"v1": errors.New("timeout")} },
+			vservers: []string{"v2"},
+			authOK:   []float64{1},
+			failed:   1,
+		},
+		{
+			name:     "user data fails",
+			modify:   func(client *fakeClient) { client.userDataErr = errors.New("timeout") },
+			vservers: []string{"v1", "v2"},
+			authOK:   []float64{1},
+			failed:   1,
+		},
+		{
+			name:     "monthly traffic fails",
+			modify:   func(client *fakeClient) { client.monthErr = map[string]error{"v1": errors.New("timeout")} },
+			opts:     []Option{WithTrafficHistory(1)},
+			vservers: []string{"v1", "v2"},
+			authOK:   []float64{1},
+			failed:   1,
+		},
+		{
+			name:     "daily traffic fails",
+			modify:   func(client *fakeClient) { client.dayErr = map[string]error{"v2": errors.New("timeout")} },
+			opts:     []Option{WithDailyTraffic()},
+			vservers: []string{"v1", "v2"},
+			authOK:   []float64{1},
+			failed:   2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newFakeClient("", "")
+			test.modify(client)
+			collector := newTestCollector(t, client, test.opts...)
+			gathered := gather(t, collector)
+			assertVServers(t, gathered, "scp_cpu_cores", test.vservers...)
+			assertVServers(t, gathered, "scp_monthlytraffic_total_bytes", test.vservers...)
+			var authOK []float64
+			for _, metric := range gathered["scp_api_auth_ok"] {
+				authOK = append(authOK, metric.Gauge.GetValue())
+			}
+			if len(authOK) != len(test.authOK) || (len(authOK) == 1 && authOK[0] != test.authOK[0]) {
+				t.Errorf("expected scp_api_auth_ok %v, got %v", test.authOK, authOK)
+			}
+			if len(gathered["scp_exporter_backend_info"]) != 1 {
+				t.Error("expected scp_exporter_backend_info to be exported")
+			}
+			if failed := collector.FailedRequests(); failed != test.failed {
+				t.Errorf("expected %d failed requests, got %d", test.failed, failed)
+			}
+		})
+	}
+}
+
+func TestCollectConsecutiveFailures(t *testing.T) {
+	client := newFakeClient("")
+	client.serversErr = errors.New("connection refused")
+	collector := newTestCollector(t, client)
+	for want := 1.0; want <= 3; want++ {
+		gathered := gather(t, collector)
+		if got := value(t, "scp_api_consecutive_failures", gathered["scp_api_consecutive_failures"]); got != want {
+			t.Errorf("expected %g consecutive failures, got %g", want, got)
+		}
+	}
+}
+
+func TestCollectNilFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(client *fakeClient)
+		opts     []Option
+		vservers []string
+	}{
+		{
+			name:   "no vservers",
+			modify: func(client *fakeClient) { client.servers = &scpclient.GetVServersResponse{} },
+		},
+		{
+			name: "nil and empty names in listing",
+			modify: func(client *fakeClient) {
+				empty := ""
+				client.servers.Return_ = append(client.servers.Return_, nil, &empty)
+			},
+			vservers: []string{"v1", "v2"},
+		},
+		{
+			name:     "nil server information",
+			modify:   func(client *fakeClient) { client.info["v1"] = &scpclient.GetVServerInformationResponse{} },
+			vservers: []string{"v2"},
+		},
+		{
+			name:     "nil current month",
+			modify:   func(client *fakeClient) { client.info["v1"].Return_.CurrentMonth = nil },
+			vservers: []string{"v1", "v2"},
+		},
+		{
+			name: "nil lists",
+			modify: func(client *fakeClient) {
+				info := client.info["v1"].Return_
+				info.Ips, info.ServerInterfaces, info.ServerDisks = nil, nil, nil
+			},
+			vservers: []string{"v1", "v2"},
+		},
+		{
+			name: "nil list entries",
+			modify: func(client *fakeClient) {
+				info := client.info["v1"].Return_
+				info.Ips = append(info.Ips, nil)
+				info.ServerInterfaces = append(info.ServerInterfaces, nil, &scpclient.ServerInterface{Mac: "02:00:00:00:00:02", Ipv4IP: []*string{nil}})
+				info.ServerDisks = append(info.ServerDisks, nil)
+			},
+			vservers: []string{"v1", "v2"},
+		},
+		{
+			name:     "empty uptime",
+			modify:   func(client *fakeClient) { client.info["v1"].Return_.Uptime = "" },
+			vservers: []string{"v1", "v2"},
+		},
+		{
+			name:     "nil user data",
+			modify:   func(client *fakeClient) { client.userData = &scpclient.GetUserDataResponse{} },
+			vservers: []string{"v1", "v2"},
+		},
+		{
+			name:     "nil monthly traffic",
+			modify:   func(client *fakeClient) {},
+			opts:     []Option{WithTrafficHistory(2)},
+			vservers: []string{"v1", "v2"},
+		},
+		{
+			name: "nil daily traffic",
+			modify: func(client *fakeClient) {
+				client.day = map[string]*scpclient.GetVServerTrafficOfDayResponse{"v1": {Return_: &scpclient.TrafficDayObject{}}}
+			},
+			opts:     []Option{WithDailyTraffic()},
+			vservers: []string{"v1", "v2"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newFakeClient("", "")
+			test.modify(client)
+			collector := newTestCollector(t, client, append([]Option{WithStateChangeLogs()}, test.opts...)...)
+			// The second collection compares the state with the first one
+			gather(t, collector)
+			gathered := gather(t, collector)
+			assertVServers(t, gathered, "scp_cpu_cores", test.vservers...)
+			assertVServers(t, gathered, "scp_server_status", test.vservers...)
+			if got := value(t, "scp_api_auth_ok", gathered["scp_api_auth_ok"]); got != 1 {
+				t.Errorf("expected scp_api_auth_ok 1, got %g", got)
+			}
+			if inventory := collector.inventory.Load(); inventory == nil || len(inventory.Servers) != len(test.vservers) {
+				t.Errorf("expected an inventory of %d vservers, got %+v", len(test.vservers), inventory)
+			}
+		})
+	}
+}
+
+// Run with -race, the collector is shared by parallel scrapes, remote write and the debug endpoint
+func TestCollectConcurrent(t *testing.T) {
+	for _, interval := range []time.Duration{0, time.Millisecond} {
+		t.Run(interval.String(), func(t *testing.T) {
+			collector := newTestCollector(t, newFakeClient("web-1", "", "web-1"),
+				WithVServerLabel(VServerLabelNickname), WithStateChangeLogs(), WithTrafficHistory(1), WithDailyTraffic(),
+				WithServerListTTL(time.Minute), WithMinCollectInterval(interval), WithRateLimits(NewRateLimits(nil)),
+				WithDroppedLabels(map[string][]string{"scp_server_info": {"nickname"}}))
+			var wg sync.WaitGroup
+			for i := range 8 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range 10 {
+						switch i % 4 {
+						case 0:
+							collector.SetCredentials("123456", "secret")
+						case 1:
+							collector.RefreshServerList()
+						case 2:
+							collector.DebugCollect()
+						}
+						ch := make(chan prometheus.Metric)
+						go func() {
+							collector.Collect(ch)
+							close(ch)
+						}()
+						for metric := range ch {
+							if err := metric.Write(&dto.Metric{}); err != nil {
+								t.Error(err)
+							}
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			gathered := gather(t, collector)
+			assertVServers(t, gathered, "scp_cpu_cores", "v2", "web-1-v1", "web-1-v3")
+		})
+	}
+}
+
+func TestLogsDoNotContainPassword(t *testing.T) {
+	const password = "s3cr3t-password"
+	tests := []struct {
+		name   string
+		modify func(client *fakeClient)
+		logged string
+	}{
+		{"collected", func(client *fakeClient) {}, "level=DEBUG"},
+		{"credentials rejected", func(client *fakeClient) {
+			client.serversErr = &soap.SOAPFault{Code: "soap:Server", String: "validation error"}
+		}, "authentication failed"},
+		{"information of a vserver fails", func(client *fakeClient) {
+			client.infoErr = map[string]error{"v1": errors.New("timeout")}
+		}, "timeout"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newFakeClient("web-1", "db-1")
+			test.modify(client)
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			collector, err := NewScpCollector(client, logger, WithCredentials("123456", password))
+			if err != nil {
+				t.Fatal(err)
+			}
+			gather(t, collector)
+			if !strings.Contains(logs.String(), test.logged) {
+				t.Fatalf("expected %q to be logged, got:\n%s", test.logged, logs.String())
+			}
+			if strings.Contains(logs.String(), password) {
+				t.Errorf("password was logged:\n%s", logs.String())
+			}
+		})
+	}
+}
+
+func TestCollectTrafficCounters(t *testing.T) {
+	client := newFakeClient("web-1")
+	collector := newTestCollector(t, client)
+	trafficIn := func() float64 {
+		t.Helper()
+		return value(t, "scp_traffic_in_bytes_total", gather(t, collector)["scp_traffic_in_bytes_total"]) / 1024 / 1024
+	}
+	if got := trafficIn(); got != 0 {
+		t.Errorf("expected the counter to start at 0, got %g MiB", got)
+	}
+	client.info["v1"].Return_.CurrentMonth = &scpclient.TrafficMonthObject{Year: 2024, Month: 11, In: 100, Out: 50, Total: 150}
+	client.month = map[string]*scpclient.GetVServerTrafficOfMonthResponse{
+		"v1": {Return_: &scpclient.TrafficMonthObject{Year: 2024, Month: 10, In: 1500, Out: 2100, Total: 3600}},
+	}
+	// The final traffic of October counts from the first collection on, plus the traffic of November
+	if got := trafficIn(); got != 1500-1024+100 {
+		t.Errorf("expected %d MiB after the rollover, got %g MiB", 1500-1024+100, got)
+	}
+	client.servers.Return_ = nil
+	gather(t, collector)
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if len(collector.traffic) != 0 {
+		t.Errorf("expected the counter of the vserver no longer listed to be dropped, got %v", collector.traffic)
+	}
+}
+
+func TestCollectTrafficHistoryCache(t *testing.T) {
+	client := newFakeClient("web-1", "db-1")
+	client.month = map[string]*scpclient.GetVServerTrafficOfMonthResponse{
+		"v1": {Return_: &scpclient.TrafficMonthObject{In: 10, Out: 20, Total: 30}},
+	}
+	collector := newTestCollector(t, client, WithTrafficHistory(3))
+	gathered := gather(t, collector)
+	if got := len(gathered["scp_monthlytraffic_in_bytes"]); got != 2+3 {
+		t.Errorf("expected the current and 3 previous months of v1 and the current month of v2, got %d series", got)
+	}
+	// Months without traffic aren't cached, v2 is asked again
+	if got := client.monthCalls.Load(); got != 2*3 {
+		t.Fatalf("expected 3 previous months of 2 vservers to be fetched, got %d calls", got)
+	}
+	gather(t, collector)
+	if got := client.monthCalls.Load(); got != 2*3+3 {
+		t.Errorf("expected the completed months of v1 to be cached, got %d calls", got)
+	}
+	// The cache moves along with the current month
+	client.info["v1"].Return_.CurrentMonth = &scpclient.TrafficMonthObject{Year: 2024, Month: 11, In: 100, Out: 50, Total: 150}
+	gather(t, collector)
+	collector.mu.Lock()
+	var months []int32
+	for index := range collector.months["v1"] {
+		months = append(months, index)
+	}
+	collector.mu.Unlock()
+	slices.Sort(months)
+	if want := []int32{monthIndex(2024, 8), monthIndex(2024, 9), monthIndex(2024, 10)}; !slices.Equal(months, want) {
+		t.Errorf("expected the cached months %v, got %v", want, months)
+	}
+	client.servers.Return_ = client.servers.Return_[1:]
+	gather(t, collector)
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if _, ok := collector.months["v1"]; ok {
+		t.Error("expected the cached months of the vserver no longer listed to be dropped")
+	}
+}
+
+// The created timestamp is the first collection of the vserver, when the counter was 0, so Prometheus doesn't ingest a
+// jump from 0 to the traffic of the month
+func TestCollectTrafficCountersCreated(t *testing.T) {
+	client := newFakeClient("web-1")
+	collector := newTestCollector(t, client)
+	before := time.Now()
+	first := gather(t, collector)["scp_traffic_in_bytes_total"]
+	if got := value(t, "scp_traffic_in_bytes_total", first); got != 0 {
+		t.Fatalf("expected the first sample to be 0, got %g", got)
+	}
+	created := first[0].Counter.GetCreatedTimestamp().AsTime()
+	if created.Before(before.Truncate(time.Second)) || created.After(time.Now()) {
+		t.Errorf("expected the created timestamp to be the first collection, got %s", created)
+	}
+	client.info["v1"].Return_.CurrentMonth.In += 100
+	second := gather(t, collector)["scp_traffic_in_bytes_total"]
+	if got := second[0].Counter.GetCreatedTimestamp().AsTime(); !got.Equal(created) {
+		t.Errorf("expected the created timestamp to stay %s, got %s", created, got)
+	}
+	if got := value(t, "scp_traffic_in_bytes_total", second); got != 100*1024*1024 {
+		t.Errorf("expected 100 MiB, got %g", got)
+	}
+}
+
+// gatherText gathers registry and returns the metrics except the excluded ones in the text format
+func gatherText(t testing.TB, registry prometheus.Gatherer, excluded ...string) string {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text bytes.Buffer
+	for _, family := range families {
+		if slices.Contains(excluded, family.GetName()) {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(&text, family); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return text.String()
+}
+
+func TestCollectMinInterval(t *testing.T) {
+	client := newFakeClient("web-1", "db-1")
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(newTestCollector(t, client, WithMinCollectInterval(time.Hour)))
+	first := gatherText(t, registry, "scp_collections_throttled_total")
+	calls := client.calls.Load()
+	if calls == 0 {
+		t.Fatal("expected the first scrape to call the webservice")
+	}
+	second := gatherText(t, registry, "scp_collections_throttled_total")
+	if got := client.calls.Load(); got != calls {
+		t.Errorf("expected no calls within the minimum interval, got %d", got-calls)
+	}
+	if first != second {
+		t.Errorf("expected the metrics of the first scrape to be replayed, got:\n%s\nwant:\n%s", second, first)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == "scp_collections_throttled_total" {
+			if got := value(t, family.GetName(), family.Metric); got != 2 {
+				t.Errorf("expected the two scrapes after the first to be throttled, got %g", got)
+			}
+			return
+		}
+	}
+	t.Error("expected scp_collections_throttled_total to be exported")
+}
+
+func TestCollectMinIntervalDisabled(t *testing.T) {
+	client := newFakeClient("web-1")
+	collector := newTestCollector(t, client)
+	gather(t, collector)
+	calls := client.calls.Load()
+	gathered := gather(t, collector)
+	if got := client.calls.Load(); got == calls {
+		t.Error("expected every scrape to call the webservice without a minimum interval")
+	}
+	if got := value(t, "scp_collections_throttled_total", gathered["scp_collections_throttled_total"]); got != 0 {
+		t.Errorf("expected no throttled scrapes, got %g", got)
+	}
+}
+
+func TestCollectServerListCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		infoErr  map[string]error
+		between  func(collector *ScpCollector)
+		listings int64
+	}{
+		{
+			name:     "within the TTL",
+			between:  func(collector *ScpCollector) {},
+			listings: 1,
+		},
+		{
+			name: "TTL expired",
+			between: func(collector *ScpCollector) {
+				collector.mu.Lock()
+				collector.serverList.expires = time.Now().Add(-time.Second)
+				collector.mu.Unlock()
+			},
+			listings: 2,
+		},
+		{
+			name:     "credentials changed",
+			between:  func(collector *ScpCollector) { collector.SetCredentials("123456", "changed") },
+			listings: 2,
+		},
+		{
+			name:     "same credentials set again",
+			between:  func(collector *ScpCollector) { collector.SetCredentials("123456", "secret") },
+			listings: 2,
+		},
+		{
+			name:     "refreshed",
+			between:  (*ScpCollector).RefreshServerList,
+			listings: 2,
+		},
+		{
+			name:     "detail call failed",
+			infoErr:  map[string]error{"v2": errors.New("timeout")},
+			between:  func(collector *ScpCollector) {},
+			listings: 2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newFakeClient("web-1", "db-1")
+			client.infoErr = test.infoErr
+			collector := newTestCollector(t, client, WithServerListTTL(time.Hour))
+			gather(t, collector)
+			test.between(collector)
+			gathered := gather(t, collector)
+			if got := client.listings.Load(); got != test.listings {
+				t.Errorf("expected %d listings, got %d", test.listings, got)
+			}
+			if got := value(t, "scp_servers_total", gathered["scp_servers_total"]); got != 2 {
+				t.Errorf("expected 2 vservers from the listing, got %g", got)
+			}
+		})
+	}
+}
+
+func TestCollectServerFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   ServerFilter
+		vservers []string
+	}{
+		{"no filter", ServerFilter{}, []string{"v1", "v2", "v3"}},
+		{"exclude by name", ServerFilter{Exclude: regexp.MustCompile(`^v2$`)}, []string{"v1", "v3"}},
+		{"exclude by nickname", ServerFilter{Exclude: regexp.MustCompile(`^web-`)}, []string{"v2"}},
+		{"include by name", ServerFilter{Include: regexp.MustCompile(`^v1$`)}, []string{"v1"}},
+		{"include by nickname", ServerFilter{Include: regexp.MustCompile(`^web-`)}, []string{"v1", "v3"}},
+		{"exclude wins", ServerFilter{Include: regexp.MustCompile(`^web-`), Exclude: regexp.MustCompile(`-2$`)}, []string{"v1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gathered := gather(t, newTestCollector(t, newFakeClient("web-1", "db-1", "web-2"), WithServerFilter(test.filter)))
+			for _, name := range []string{"scp_cpu_cores", "scp_server_info", "scp_monthlytraffic_in_bytes"} {
+				assertVServers(t, gathered, name, test.vservers...)
+			}
+			if got := value(t, "scp_servers_total", gathered["scp_servers_total"]); got != 3 {
+				t.Errorf("expected the 3 listed vservers, got %g", got)
+			}
+			if got, want := value(t, "scp_servers_filtered_total", gathered["scp_servers_filtered_total"]), float64(3-len(test.vservers)); got != want {
+				t.Errorf("expected %g filtered vservers, got %g", want, got)
+			}
+		})
+	}
+}
+
+func TestCollectTrafficGrace(t *testing.T) {
+	// The grace period is measured from the start of the current month of the vserver, this one is the real one
+	now := time.Now()
+	year, month := int32(now.Year()), int32(now.Month())
+	previousYear, previous := previousMonth(year, month, 1)
+	tests := []struct {
+		name     string
+		opts     []Option
+		grace    time.Duration
+		previous bool
+	}{
+		{"inside the grace period", []Option{WithTrafficGrace(1000 * time.Hour)}, 0, true},
+		{"after the grace period", []Option{WithTrafficGrace(time.Nanosecond)}, 0, false},
+		// The options reject the combination, the grace is set on the collector to check it is ignored anyway
+		{"plain traffic labels", []Option{WithPlainTrafficLabels()}, 1000 * time.Hour, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newFakeClient("web-1")
+			client.info["v1"].Return_.CurrentMonth = &scpclient.TrafficMonthObject{Year: year, Month: month, In: 100, Out: 50, Total: 150}
+			client.month = map[string]*scpclient.GetVServerTrafficOfMonthResponse{
+				"v1": {Return_: &scpclient.TrafficMonthObject{Year: previousYear, Month: previous, In: 1000, Out: 500, Total: 1500}},
+			}
+			collector := newTestCollector(t, client, test.opts...)
+			if test.grace > 0 {
+				collector.trafficGrace = test.grace
+			}
+			series := gather(t, collector)["scp_monthlytraffic_in_bytes"]
+			if got := slices.Contains(labelValues(series, "month"), strconv.Itoa(int(previous))); got != test.previous {
+				t.Errorf("expected the previous month to be exported: %t", test.previous)
+			}
+			want := 1
+			if test.previous {
+				want = 2
+			}
+			if len(series) != want {
+				t.Errorf("expected %d series, got %d", want, len(series))
+			}
+			if got := client.monthCalls.Load(); got != int64(want-1) {
+				t.Errorf("expected %d calls for the previous month, got %d", want-1, got)
+			}
+		})
+	}
+}
+
+func TestCollectStateChangeLogs(t *testing.T) {
+	client := newFakeClient("web-1", "db-1")
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	collector, err := NewScpCollector(client, logger, WithCredentials("123456", "secret"), WithStateChangeLogs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := func() []string {
+		t.Helper()
+		logs.Reset()
+		gather(t, collector)
+		var lines []string
+		for _, line := range strings.Split(logs.String(), "\n") {
+			if strings.Contains(line, "vserver state changed") {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+	if got := changes(); len(got) != 0 {
+		t.Errorf("expected no changes to be logged for the first collection, got %q", got)
+	}
+	if got := changes(); len(got) != 0 {
+		t.Errorf("expected no changes to be logged while the state is steady, got %q", got)
+	}
+	info := client.info["v1"].Return_
+	info.Status = "offline"
+	info.RescueEnabled = true
+	info.ServerInterfaces[0].TrafficThrottled = true
+	got := changes()
+	want := []string{
+		"vserver=v1 field=status old=online new=offline",
+		"vserver=v1 field=rescue_active old=false new=true",
+		"vserver=v1 field=interface_throttled mac=02:00:00:00:00:01 old=false new=true",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected one line per change, got %q", got)
+	}
+	for i := range want {
+		if !strings.HasSuffix(got[i], want[i]) {
+			t.Errorf("expected a line ending in %q, got %q", want[i], got[i])
+		}
+	}
+	if got := changes(); len(got) != 0 {
+		t.Errorf("expected no changes to be logged after the transition, got %q", got)
+	}
+}
@@ -0,0 +1,67 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import "github.com/mrueg/netcupscp-exporter/pkg/scpclient"
+
+// metricShapes selects the legacy shape of the first release for the metrics whose labels changed since then. The label
+// names and the label values of these metrics are both mapped here, so the two can't drift apart.
+type metricShapes struct {
+	// serverStatus keeps the nickname label on scp_server_status
+	serverStatus bool
+	// interfaces exports scp_interface_throttled once per IP with all interface attributes as labels
+	interfaces bool
+	// ipInfo exports scp_ip_info with the vserver and ip labels only
+	ipInfo bool
+}
+
+// serverStatusLabels returns the variable labels of scp_server_status
+func (s metricShapes) serverStatusLabels() []string {
+	if s.serverStatus {
+		return []string{"vserver", "status", "nickname"}
+	}
+	return []string{"vserver", "status"}
+}
+
+// serverStatusValues returns the label values of scp_server_status for a vserver
+func (s metricShapes) serverStatusValues(label string, info *scpclient.VServerInformationObject) []string {
+	if s.serverStatus {
+		return []string{label, info.Status, info.VServerNickname}
+	}
+	return []string{label, info.Status}
+}
+
+// ipInfoLabels returns the variable labels of scp_ip_info
+func (s metricShapes) ipInfoLabels() []string {
+	if s.ipInfo {
+		return []string{"vserver", "ip"}
+	}
+	return []string{"vserver", "ip", "ip_type", "mac"}
+}
+
+// ipInfoValues returns the label values of scp_ip_info for an IP of a vserver, mac is the MAC of its interface
+func (s metricShapes) ipInfoValues(label string, ip string, mac string) []string {
+	if s.ipInfo {
+		return []string{label, ip}
+	}
+	return []string{label, ip, ipType(ip), mac}
+}
+
+// ifaceThrottledLabels returns the variable labels of scp_interface_throttled
+func (s metricShapes) ifaceThrottledLabels() []string {
+	if s.interfaces {
+		return []string{"vserver", "driver", "id", "ip", "ip_type", "mac", "throttle_message"}
+	}
+	return []string{"vserver", "mac"}
+}
+
+// ifaceThrottledValues returns the label values of scp_interface_throttled for an interface of a vserver. In the legacy
+// shape there is one series per IP of the interface, ip and ipType are ignored otherwise.
+func (s metricShapes) ifaceThrottledValues(label string, iface *scpclient.ServerInterface, ip string, ipType string) []string {
+	if s.interfaces {
+		return []string{label, iface.Driver, iface.Id, ip, ipType, iface.Mac, iface.TrafficThrottledMessage}
+	}
+	return []string{label, iface.Mac}
+}
@@ -0,0 +1,29 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mrueg/netcupscp-exporter/pkg/fixtures"
+	"github.com/mrueg/netcupscp-exporter/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCompatV0Metrics(t *testing.T) {
+	collector, err := metrics.NewScpCollector(fixtures.NewClient(fixtures.Demo()), testLogger(), metrics.WithCompatV0Metrics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.Open("testdata/v0-metrics.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer golden.Close()
+	if err := testutil.CollectAndCompare(collector, golden, "scp_interface_throttled", "scp_ip_info", "scp_server_status"); err != nil {
+		t.Error(err)
+	}
+}
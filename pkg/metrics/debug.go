@@ -0,0 +1,139 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hooklift/gowsdl/soap"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Outcomes of a webservice call in a CollectionReport
+const (
+	outcomeOK        = "ok"
+	outcomeSOAPFault = "soap_fault"
+	outcomeError     = "error"
+)
+
+// Statuses of a vserver in a CollectionReport
+const (
+	serverOK       = "ok"
+	serverFailed   = "failed"
+	serverFiltered = "filtered"
+)
+
+// CollectionReport describes the outcome of a single collection triggered by DebugCollect
+type CollectionReport struct {
+	CollectedAt     time.Time                          `json:"collected_at"`
+	Cached          bool                               `json:"cached"`
+	DurationSeconds float64                            `json:"duration_seconds"`
+	Samples         int                                `json:"samples"`
+	Calls           map[string]map[string]int          `json:"calls"`
+	Servers         map[string]*CollectionServerReport `json:"servers"`
+}
+
+// newCollectionReport returns an empty report
+func newCollectionReport() *CollectionReport {
+	return &CollectionReport{Calls: make(map[string]map[string]int), Servers: make(map[string]*CollectionServerReport)}
+}
+
+// CollectionServerReport describes the outcome of the webservice calls for a single vserver
+type CollectionServerReport struct {
+	Status string   `json:"status"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// callOutcome classifies the result of a webservice call, HTTP errors are reported by status code
+func callOutcome(err error) string {
+	var fault *soap.SOAPFault
+	var httpErr *soap.HTTPError
+	switch {
+	case err == nil:
+		return outcomeOK
+	case errors.As(err, &fault):
+		return outcomeSOAPFault
+	case errors.As(err, &httpErr):
+		return "http_" + strconv.Itoa(httpErr.StatusCode)
+	}
+	return outcomeError
+}
+
+// record adds a webservice call to the report, unless it is nil. vserver is "" for calls not related to a single vserver.
+func (report *CollectionReport) record(method string, vserver string, err error) {
+	if report == nil {
+		return
+	}
+	if report.Calls[method] == nil {
+		report.Calls[method] = make(map[string]int)
+	}
+	report.Calls[method][callOutcome(err)]++
+	if vserver != "" && err != nil {
+		report.serverFailed(vserver, fmt.Errorf("%s: %w", method, err))
+	}
+}
+
+// server sets the status of a vserver, unless it already failed or report is nil
+func (report *CollectionReport) server(vserver string, status string) {
+	if report == nil {
+		return
+	}
+	if server, ok := report.Servers[vserver]; ok && server.Status == serverFailed {
+		return
+	}
+	report.Servers[vserver] = &CollectionServerReport{Status: status}
+}
+
+// serverFailed marks a vserver as failed and adds err to its errors, unless report is nil
+func (report *CollectionReport) serverFailed(vserver string, err error) {
+	if report == nil {
+		return
+	}
+	server, ok := report.Servers[vserver]
+	if !ok {
+		server = &CollectionServerReport{}
+		report.Servers[vserver] = server
+	}
+	server.Status = serverFailed
+	server.Errors = append(server.Errors, err.Error())
+}
+
+// DebugCollect runs a collection like a scrape and reports the outcome of every webservice call, the metrics are
+// discarded. Within the minimum collection interval the report of the previous collection is returned with Cached set.
+func (collector *ScpCollector) DebugCollect() *CollectionReport {
+	report := newCollectionReport()
+	ch := make(chan prometheus.Metric)
+	samples := make(chan int)
+	go func() {
+		count := 0
+		for range ch {
+			count++
+		}
+		samples <- count
+	}()
+	collector.collectGuarded(ch, report)
+	close(ch)
+	report.Samples = <-samples
+	return report
+}
+
+// DebugCollectHandler serves the report of DebugCollect as JSON. It is subject to the same minimum collection interval as
+// scrapes, which protects the webservice from repeated requests.
+func (collector *ScpCollector) DebugCollectHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := collector.DebugCollect()
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		w.Header().Set("Content-Type", "application/json")
+		if err := encoder.Encode(report); err != nil {
+			collector.logger.Error("Unable to encode debug collection report", "error", err.Error())
+		}
+	})
+}
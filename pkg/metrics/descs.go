@@ -0,0 +1,53 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// describedMetric is a metric described by a collector
+type describedMetric struct {
+	desc   *prometheus.Desc
+	labels []string
+}
+
+// metricTable records the name and variable labels of the metrics of a collector when their descriptions are built, as
+// prometheus.Desc doesn't expose them
+type metricTable map[string]describedMetric
+
+// newDesc builds the description of a metric and records it in the table
+func (t metricTable) newDesc(name string, help string, labels []string, constLabels prometheus.Labels) *prometheus.Desc {
+	desc := prometheus.NewDesc(name, help, labels, constLabels)
+	t[name] = describedMetric{desc: desc, labels: labels}
+	return desc
+}
+
+// add records the description of a metric built elsewhere, e.g. by a metric vector
+func (t metricTable) add(name string, desc *prometheus.Desc, labels []string) {
+	t[name] = describedMetric{desc: desc, labels: labels}
+}
+
+// names returns the names of the metrics in the table, sorted
+func (t metricTable) names() []string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// nameOf returns the name of the metric described by desc, or "" if it isn't in the table
+func (t metricTable) nameOf(desc *prometheus.Desc) string {
+	for name, metric := range t {
+		if metric.desc == desc {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,25 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package metrics implements a metric collector to gather metrics from the NetCup API.
+//
+// The package has no dependencies on flags or the process: it doesn't register with the default registry, exit or
+// configure logging, so the collector can be embedded into other exporters:
+//
+//	client := scpclient.NewWSEndUser(soap.NewClient("https://www.servercontrolpanel.de/SCP/WSEndUser",
+//		soap.WithHTTPClient(&http.Client{Timeout: 10 * time.Second})))
+//	registry := prometheus.NewRegistry()
+//	collector, err := metrics.Register(registry, client, slog.Default(),
+//		metrics.WithCredentials(loginName, password),
+//		metrics.WithNicknameLabels(),
+//		metrics.WithConstLabels(prometheus.Labels{"provider": "netcup"}),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+//
+// collector.InventoryHandler serves the vservers of the last collection as JSON, SetCredentials replaces the credentials
+// at runtime.
+package metrics
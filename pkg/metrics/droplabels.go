@@ -0,0 +1,111 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// droppedLabelsByDesc resolves the labels to drop, keyed by metric name, to the descriptions of the described metrics.
+// It fails for metrics that aren't described and labels the metric doesn't have, const labels can't be dropped.
+func droppedLabelsByDesc(described metricTable, dropped map[string][]string) (map[*prometheus.Desc]map[string]bool, error) {
+	if len(dropped) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(dropped))
+	for name := range dropped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	byDesc := make(map[*prometheus.Desc]map[string]bool, len(dropped))
+	for _, name := range names {
+		metric, ok := described[name]
+		if !ok {
+			return nil, fmt.Errorf("can't drop labels of unknown metric %s", name)
+		}
+		labels := make(map[string]bool, len(dropped[name]))
+		for _, label := range dropped[name] {
+			if !slices.Contains(metric.labels, label) {
+				return nil, fmt.Errorf("metric %s has no label %q, its labels are %s", name, label, strings.Join(metric.labels, ", "))
+			}
+			labels[label] = true
+		}
+		byDesc[metric.desc] = labels
+	}
+	return byDesc, nil
+}
+
+// droppedLabelsMetric is a metric whose dropped labels are exported with an empty value, which Prometheus treats like a
+// missing label
+type droppedLabelsMetric struct {
+	prometheus.Metric
+	dropped map[string]bool
+}
+
+// Write implements prometheus.Metric
+func (m droppedLabelsMetric) Write(out *dto.Metric) error {
+	if err := m.Metric.Write(out); err != nil {
+		return err
+	}
+	empty := ""
+	for _, label := range out.Label {
+		if m.dropped[label.GetName()] {
+			label.Value = &empty
+		}
+	}
+	return nil
+}
+
+// dropLabels forwards the metrics sent to the returned channel to ch with the dropped labels emptied, the returned
+// function must be called once all metrics are sent. Series that only differ in dropped labels would fail the scrape,
+// only the first of them is forwarded.
+func (collector *ScpCollector) dropLabels(ch chan<- prometheus.Metric) (chan<- prometheus.Metric, func()) {
+	metrics := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		seen := make(map[string]bool)
+		discarded := make(map[*prometheus.Desc]int)
+		for metric := range metrics {
+			if dropped, ok := collector.droppedLabels[metric.Desc()]; ok {
+				metric = droppedLabelsMetric{Metric: metric, dropped: dropped}
+				out := &dto.Metric{}
+				if err := metric.Write(out); err == nil {
+					key := seriesKey(metric.Desc(), out.Label)
+					if seen[key] {
+						discarded[metric.Desc()]++
+						continue
+					}
+					seen[key] = true
+				}
+			}
+			ch <- metric
+		}
+		for desc, count := range discarded {
+			collector.logger.Debug("Discarded series that only differ in dropped labels", "metric", collector.described.nameOf(desc), "count", count)
+		}
+	}()
+	return metrics, func() {
+		close(metrics)
+		<-done
+	}
+}
+
+// seriesKey identifies a series of desc by its label values
+func seriesKey(desc *prometheus.Desc, labels []*dto.LabelPair) string {
+	var key strings.Builder
+	fmt.Fprintf(&key, "%p", desc)
+	for _, label := range labels {
+		key.WriteByte(0)
+		key.WriteString(label.GetValue())
+	}
+	return key.String()
+}
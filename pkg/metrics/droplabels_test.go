@@ -0,0 +1,30 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCollectDroppedLabels(t *testing.T) {
+	collector := newTestCollector(t, newFakeClient("web-1", "db-1"), WithNicknameLabels(), WithDroppedLabels(map[string][]string{
+		"scp_cpu_cores":   {"vserver"},
+		"scp_server_info": {"nickname"},
+	}))
+	// The pedantic registry of gather fails on series that only differ in dropped labels
+	gathered := gather(t, collector)
+	if got := labelValues(gathered["scp_cpu_cores"], "vserver"); len(got) != 1 || got[0] != "" {
+		t.Errorf("expected one series of scp_cpu_cores with an empty vserver, got %q", got)
+	}
+	if got := value(t, "scp_cpu_cores", gathered["scp_cpu_cores"]); got != 2 {
+		t.Errorf("expected the cpu cores of the first vserver, got %g", got)
+	}
+	if got := labelValues(gathered["scp_server_info"], "nickname"); strings.Join(got, ",") != "," {
+		t.Errorf("expected two series of scp_server_info with an empty nickname, got %q", got)
+	}
+	// Metrics without dropped labels are unchanged
+	assertVServers(t, gathered, "scp_memory_bytes", "db-1", "web-1")
+}
@@ -0,0 +1,86 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics_test
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mrueg/netcupscp-exporter/pkg/fixtures"
+	"github.com/mrueg/netcupscp-exporter/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testLogger returns a logger discarding all messages
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// The listing contains a nil and an empty name, one vserver has no information and the others lack most fields and
+// traffic
+func TestCollectNilFieldsFixtures(t *testing.T) {
+	collector, err := metrics.NewScpCollector(fixtures.NewClient(os.DirFS("testdata/nil-fields")), testLogger(),
+		metrics.WithTrafficHistory(1), metrics.WithDailyTraffic(), metrics.WithStateChangeLogs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatal(err)
+	}
+	// Twice, as the second collection compares the state of the vservers with the first one
+	for range 2 {
+		if err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP scp_api_auth_ok Whether the webservice accepted the credentials (1) or rejected them (0), missing if it couldn't be reached
+# TYPE scp_api_auth_ok gauge
+scp_api_auth_ok 1
+# HELP scp_cpu_cores Number of CPU cores
+# TYPE scp_cpu_cores gauge
+scp_cpu_cores{vserver="v2202410000000000001"} 4
+scp_cpu_cores{vserver="v2202410000000000003"} 0
+# HELP scp_interface_address_info IPs assigned to a network interface
+# TYPE scp_interface_address_info gauge
+# HELP scp_interfaces_count Number of network interfaces attached to this server
+# TYPE scp_interfaces_count gauge
+scp_interfaces_count{vserver="v2202410000000000001"} 1
+scp_interfaces_count{vserver="v2202410000000000003"} 0
+# HELP scp_ip_info IPs assigned to this server
+# TYPE scp_ip_info gauge
+scp_ip_info{ip="192.0.2.10",ip_type="ipv4",mac="",vserver="v2202410000000000001"} 1
+# HELP scp_disks_count Number of disks attached to this server
+# TYPE scp_disks_count gauge
+scp_disks_count{vserver="v2202410000000000001"} 0
+scp_disks_count{vserver="v2202410000000000003"} 0
+# HELP scp_servers_total Number of vservers in the account
+# TYPE scp_servers_total gauge
+scp_servers_total 5
+`), "scp_api_auth_ok", "scp_cpu_cores", "scp_interface_address_info", "scp_interfaces_count", "scp_ip_info", "scp_disks_count", "scp_servers_total"); err != nil {
+			t.Error(err)
+		}
+	}
+	if failed := collector.FailedRequests(); failed != 0 {
+		t.Errorf("expected no failed requests, got %d", failed)
+	}
+}
+
+// The vserver has IPv4 addresses, an IPv6 prefix and a single IPv6 address on two interfaces and an IPv4 address on none
+func TestIPTypesFixtures(t *testing.T) {
+	collector, err := metrics.NewScpCollector(fixtures.NewClient(os.DirFS("testdata/ip-types")), testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.Open("testdata/ip-types.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer golden.Close()
+	if err := testutil.CollectAndCompare(collector, golden, "scp_ip_info", "scp_ipv4_addresses_count", "scp_ipv6_prefixes_count"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,120 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mrueg/netcupscp-exporter/pkg/scpclient"
+)
+
+// Inventory is the server data of the last successful collection
+type Inventory struct {
+	CollectedAt time.Time         `json:"collected_at"`
+	Servers     []InventoryServer `json:"servers"`
+}
+
+// InventoryServer describes a single vserver of the inventory
+type InventoryServer struct {
+	Name     string          `json:"name"`
+	Nickname string          `json:"nickname"`
+	Status   string          `json:"status"`
+	IPs      []string        `json:"ips"`
+	Disks    []InventoryDisk `json:"disks"`
+}
+
+// InventoryDisk describes a disk attached to a vserver
+type InventoryDisk struct {
+	Name          string `json:"name"`
+	Driver        string `json:"driver"`
+	CapacityBytes int64  `json:"capacity_bytes"`
+	UsedBytes     int64  `json:"used_bytes"`
+}
+
+// newInventory builds the inventory from the server details fetched during a collection
+func newInventory(servers []vserverInformation) *Inventory {
+	inventory := &Inventory{CollectedAt: time.Now(), Servers: make([]InventoryServer, 0, len(servers))}
+	for _, server := range servers {
+		ips := make([]string, 0, len(server.info.Ips))
+		for _, ip := range server.info.Ips {
+			ips = append(ips, *ip)
+		}
+		disks := make([]InventoryDisk, 0, len(server.info.ServerDisks))
+		for _, disk := range server.info.ServerDisks {
+			disks = append(disks, InventoryDisk{
+				Name:          disk.Name,
+				Driver:        disk.Driver,
+				CapacityBytes: disk.Capacity * 1024 * 1024 * 1024,
+				UsedBytes:     disk.Used * 1024 * 1024 * 1024,
+			})
+		}
+		inventory.Servers = append(inventory.Servers, InventoryServer{
+			Name:     server.name,
+			Nickname: server.info.VServerNickname,
+			Status:   server.info.Status,
+			IPs:      ips,
+			Disks:    disks,
+		})
+	}
+	return inventory
+}
+
+// ListServers fetches the details of all vservers of the account regardless of the filters, without exporting metrics
+func (collector *ScpCollector) ListServers(ctx context.Context) ([]InventoryServer, error) {
+	creds := collector.credentials.Load()
+	listCtx, _ := newRequest(ctx, collector.logger)
+	response, err := collector.client.GetVServersContext(listCtx, &scpclient.GetVServers{
+		Xmlns:     requestURL,
+		LoginName: creds.loginName,
+		Password:  creds.password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]vserverInformation, 0, len(response.Return_))
+	for _, vserver := range response.Return_ {
+		if vserver == nil || *vserver == "" {
+			continue
+		}
+		infoCtx, _ := newRequest(ctx, collector.logger)
+		infoResponse, err := collector.client.GetVServerInformationContext(infoCtx, &scpclient.GetVServerInformation{
+			Xmlns:       requestURL,
+			LoginName:   creds.loginName,
+			Password:    creds.password,
+			Vservername: *vserver,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("vserver %s: %w", *vserver, err)
+		}
+		if infoResponse.Return_ == nil {
+			return nil, fmt.Errorf("vserver %s: empty server information", *vserver)
+		}
+		servers = append(servers, vserverInformation{name: *vserver, info: withoutNilEntries(infoResponse.Return_)})
+	}
+	return newInventory(servers).Servers, nil
+}
+
+// InventoryHandler serves the inventory of the last successful collection as JSON, it doesn't trigger any API calls
+func (collector *ScpCollector) InventoryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inventory := collector.inventory.Load()
+		if inventory == nil {
+			http.Error(w, "no successful collection yet, scrape the metrics endpoint first", http.StatusServiceUnavailable)
+			return
+		}
+		encoder := json.NewEncoder(w)
+		if r.URL.Query().Get("pretty") == "1" {
+			encoder.SetIndent("", "  ")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := encoder.Encode(inventory); err != nil {
+			collector.logger.Error("Unable to encode inventory", "error", err.Error())
+		}
+	})
+}
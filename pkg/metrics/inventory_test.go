@@ -0,0 +1,67 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// getInventory requests the inventory handler of collector and returns the response
+func getInventory(collector *ScpCollector) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	collector.InventoryHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/inventory", nil))
+	return recorder
+}
+
+func TestInventoryHandler(t *testing.T) {
+	client := newFakeClient("web-1")
+	collector := newTestCollector(t, client)
+	if response := getInventory(collector); response.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503 before the first collection, got %d", response.Code)
+	}
+	gather(t, collector)
+	response := getInventory(collector)
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", response.Code, response.Body)
+	}
+	if got := response.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected a JSON response, got %s", got)
+	}
+	var inventory Inventory
+	if err := json.Unmarshal(response.Body.Bytes(), &inventory); err != nil {
+		t.Fatal(err)
+	}
+	if inventory.CollectedAt.IsZero() {
+		t.Error("expected the time of the collection")
+	}
+	want := []InventoryServer{{
+		Name:     "v1",
+		Nickname: "web-1",
+		Status:   "online",
+		IPs:      []string{"192.0.2.1", "2001:db8::/64"},
+		Disks:    []InventoryDisk{{Name: "vda", Driver: "virtio", CapacityBytes: 80 << 30, UsedBytes: 20 << 30}},
+	}}
+	if !reflect.DeepEqual(inventory.Servers, want) {
+		t.Errorf("expected the servers %+v, got %+v", want, inventory.Servers)
+	}
+
+	// A failed collection keeps the inventory of the last successful one
+	failing := newFakeClient()
+	failing.serversErr = errors.New("connection refused")
+	collector.client = failing
+	gather(t, collector)
+	var kept Inventory
+	if err := json.Unmarshal(getInventory(collector).Body.Bytes(), &kept); err != nil {
+		t.Fatal(err)
+	}
+	if !kept.CollectedAt.Equal(inventory.CollectedAt) {
+		t.Errorf("expected the inventory of %s to be kept, got the one of %s", inventory.CollectedAt, kept.CollectedAt)
+	}
+}
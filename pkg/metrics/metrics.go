@@ -2,102 +2,623 @@
 // License, version 2.0. If a copy of the MPL was not distributed with this
 // file, You can obtain one at http://mozilla.org/MPL/2.0/.
 
-// Package metrics implements a metric collector to gather metrics from the NetCup API
 package metrics
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/hooklift/gowsdl/soap"
 	"github.com/mrueg/netcupscp-exporter/pkg/scpclient"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/xhit/go-str2duration/v2"
 )
 
 const requestURL = "http://enduser.service.web.vcp.netcup.de/"
 
+// Client is the part of the SCP webservice the collector calls, scpclient.WSEndUser satisfies it.
+// The context of every call carries its request ID, see RequestID.
+type Client interface {
+	GetVServersContext(ctx context.Context, request *scpclient.GetVServers) (*scpclient.GetVServersResponse, error)
+	GetVServerInformationContext(ctx context.Context, request *scpclient.GetVServerInformation) (*scpclient.GetVServerInformationResponse, error)
+	GetVServerTrafficOfMonthContext(ctx context.Context, request *scpclient.GetVServerTrafficOfMonth) (*scpclient.GetVServerTrafficOfMonthResponse, error)
+	GetVServerTrafficOfDayContext(ctx context.Context, request *scpclient.GetVServerTrafficOfDay) (*scpclient.GetVServerTrafficOfDayResponse, error)
+	GetUserDataContext(ctx context.Context, request *scpclient.GetUserData) (*scpclient.GetUserDataResponse, error)
+}
+
 // ScpCollector struct includes all the information to gather metrics
+// Collect may run concurrently, e.g. for parallel scrapes or next to remote write: the configuration is read-only after
+// NewScpCollector, state kept between collections is guarded by mu or collectMu and everything else is atomic or local to a collection.
 type ScpCollector struct {
-	client              scpclient.WSEndUser
+	client              Client
 	logger              *slog.Logger
-	loginName           *string
-	password            *string
+	credentials         atomic.Pointer[credentials]
+	failedRequests      atomic.Int64
+	consecutiveFailures atomic.Int64
+	throttled           atomic.Int64
+	inventory           atomic.Pointer[Inventory]
 	cpuCores            *prometheus.Desc
 	memory              *prometheus.Desc
 	monthlyTrafficIn    *prometheus.Desc
 	monthlyTrafficOut   *prometheus.Desc
 	monthlyTrafficTotal *prometheus.Desc
+	trafficIn           *prometheus.Desc
+	trafficOut          *prometheus.Desc
+	dailyTrafficIn      *prometheus.Desc
+	dailyTrafficOut     *prometheus.Desc
+	dailyTrafficTotal   *prometheus.Desc
+	trafficIncluded     *prometheus.Desc
+	trafficUsedRatio    *prometheus.Desc
 	serverStartTime     *prometheus.Desc
 	ipInfo              *prometheus.Desc
+	ifaceInfo           *prometheus.Desc
 	ifaceThrottled      *prometheus.Desc
+	ifaceAddressInfo    *prometheus.Desc
+	interfacesCount     *prometheus.Desc
+	ipv4AddressesCount  *prometheus.Desc
+	ipv6PrefixesCount   *prometheus.Desc
 	serverStatus        *prometheus.Desc
+	serverInfo          *prometheus.Desc
 	rescueActive        *prometheus.Desc
 	rebootRecommended   *prometheus.Desc
 	diskCapacity        *prometheus.Desc
 	diskUsed            *prometheus.Desc
 	diskOptimization    *prometheus.Desc
+	disksCount          *prometheus.Desc
+	disksCapacity       *prometheus.Desc
+	disksUsed           *prometheus.Desc
+	serversTotal        *prometheus.Desc
+	serversFiltered     *prometheus.Desc
+	backendInfo         *prometheus.Desc
+	apiAuthOK           *prometheus.Desc
+	accountInfo         *prometheus.Desc
+	apiFailureStreak    *prometheus.Desc
+	throttledTotal      *prometheus.Desc
+	apiCallsPerScrape   *prometheus.Desc
+	apiRequestDuration  *prometheus.HistogramVec
+	shapes              metricShapes
+	trafficHistory      int
+	trafficGrace        time.Duration
+	plainTrafficLabels  bool
+	dailyTraffic        bool
+	includedTraffic     map[string]int64
+	filter              ServerFilter
+	vserverLabel        string
+	logStateChanges     bool
+	rateLimits          *RateLimits
+	serverListTTL       time.Duration
+	minCollectInterval  time.Duration
+	droppedLabels       map[*prometheus.Desc]map[string]bool
+	described           metricTable
+
+	// mu guards the fields below
+	mu      sync.Mutex
+	traffic map[string]*trafficCounter
+	// months holds the traffic of completed months per vserver, keyed by monthIndex, as it doesn't change anymore
+	months map[string]map[int32]*scpclient.TrafficMonthObject
+	states map[string]serverState
+	starts map[string]time.Time
+	// authFailureLogged is the time the last authentication failure was logged, zero after a successful login
+	authFailureLogged time.Time
+	// account is the login name reported by getUserData for accountCreds, it is fetched again after SetCredentials
+	account      string
+	accountCreds *credentials
+	// serverList is the cached listing of the vservers, nil if WithServerListTTL isn't set or it was refreshed
+	serverList *serverList
+	// nicknameWarned holds the vservers the missing nickname was logged for, the warning is logged once per vserver
+	nicknameWarned map[string]bool
+
+	// collectMu serializes the collections if minCollectInterval is set and guards the result of the last one
+	collectMu     sync.Mutex
+	lastMetrics   []prometheus.Metric
+	lastCollected time.Time
+	lastReport    *CollectionReport
+}
+
+// serverList is a listing of the vservers of an account, used until it expires or a detail call fails
+type serverList struct {
+	creds    *credentials
+	vservers []*string
+	expires  time.Time
+}
+
+// credentials are used to authenticate against the SCP webservice
+type credentials struct {
+	loginName string
+	password  string
 }
 
-// NewScpCollector returns a collector object
-func NewScpCollector(client scpclient.WSEndUser, logger *slog.Logger, loginName *string, password *string) *ScpCollector {
+// ServerFilter selects the vservers to export metrics for by their name or nickname
+type ServerFilter struct {
+	Include *regexp.Regexp
+	Exclude *regexp.Regexp
+}
+
+// included reports whether no include pattern is set or one of the non-empty names matches it
+func (f ServerFilter) included(names ...string) bool {
+	if f.Include == nil {
+		return true
+	}
+	for _, name := range names {
+		if name != "" && f.Include.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded reports whether one of the non-empty names matches the exclude pattern
+func (f ServerFilter) excluded(names ...string) bool {
+	if f.Exclude == nil {
+		return false
+	}
+	for _, name := range names {
+		if name != "" && f.Exclude.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// trafficCounter accumulates the monthly traffic of a vserver across month boundaries. It starts at 0 when the vserver
+// is seen for the first time, the traffic of that month up to then isn't counted.
+type trafficCounter struct {
+	created time.Time
+	year    int32
+	month   int32
+	// in and out are the traffic of the month seen last
+	in  int64
+	out int64
+	// inStart and outStart are the traffic of the month seen last before the counter started, 0 after the first rollover
+	inStart  int64
+	outStart int64
+	// inBase and outBase are the traffic counted in the months before the one seen last
+	inBase  int64
+	outBase int64
+}
+
+// rollsOver reports whether current is the traffic of a later month than the one the counter saw last
+func (c *trafficCounter) rollsOver(current *scpclient.TrafficMonthObject) bool {
+	return c.year != 0 && monthIndex(current.Year, current.Month) > monthIndex(c.year, c.month)
+}
+
+// update folds the traffic of the current month into the counter. When the month changed, final is the final traffic of
+// the month seen before, nil if it couldn't be fetched: then the traffic after the last collection of that month is
+// lost. Traffic of a month older than the one seen last is ignored.
+func (c *trafficCounter) update(current *scpclient.TrafficMonthObject, final *scpclient.TrafficMonthObject) {
+	if c.year == 0 {
+		c.year, c.month = current.Year, current.Month
+		c.in, c.out = current.In, current.Out
+		c.inStart, c.outStart = current.In, current.Out
+		return
+	}
+	seen := c.year*12 + c.month
+	reported := current.Year*12 + current.Month
+	if reported < seen {
+		return
+	}
+	if reported > seen {
+		if final != nil {
+			c.in = max(c.in, final.In)
+			c.out = max(c.out, final.Out)
+		}
+		c.inBase += c.in - c.inStart
+		c.outBase += c.out - c.outStart
+		c.year, c.month = current.Year, current.Month
+		c.in, c.out = 0, 0
+		c.inStart, c.outStart = 0, 0
+	}
+	c.in = max(c.in, current.In)
+	c.out = max(c.out, current.Out)
+}
+
+// value returns the traffic counted since the counter started
+func (c *trafficCounter) value() (in int64, out int64) {
+	return c.inBase + c.in - c.inStart, c.outBase + c.out - c.outStart
+}
+
+// Values for WithVServerLabel
+const (
+	VServerLabelName                 = "name"
+	VServerLabelNickname             = "nickname"
+	VServerLabelNicknameFallbackName = "nickname-fallback-name"
+)
+
+// vserverInformation holds the details of a vserver fetched during a scrape
+type vserverInformation struct {
+	name string
+	info *scpclient.VServerInformationObject
+}
+
+// withoutNilEntries returns a copy of info without the nil entries of its lists, so the rest of the collector doesn't
+// need to check them. The webservice doesn't send them, but fixtures can.
+func withoutNilEntries(info *scpclient.VServerInformationObject) *scpclient.VServerInformationObject {
+	cleaned := *info
+	cleaned.Ips = slices.DeleteFunc(slices.Clone(info.Ips), isNil)
+	cleaned.ServerDisks = slices.DeleteFunc(slices.Clone(info.ServerDisks), isNil)
+	cleaned.ServerInterfaces = make([]*scpclient.ServerInterface, 0, len(info.ServerInterfaces))
+	for _, iface := range info.ServerInterfaces {
+		if iface == nil {
+			continue
+		}
+		cleanedIface := *iface
+		cleanedIface.Ipv4IP = slices.DeleteFunc(slices.Clone(iface.Ipv4IP), isNil)
+		cleanedIface.Ipv6IP = slices.DeleteFunc(slices.Clone(iface.Ipv6IP), isNil)
+		cleaned.ServerInterfaces = append(cleaned.ServerInterfaces, &cleanedIface)
+	}
+	return &cleaned
+}
+
+// isNil reports whether value is a nil pointer
+func isNil[T any](value *T) bool {
+	return value == nil
+}
+
+// vserverLabels maps the vserver names to the value of their vserver label.
+// Servers without a nickname fall back to their name, servers sharing a nickname get their name appended to keep series unique.
+// With VServerLabelNickname the missing nickname is logged once per vserver, again only if it was set in between.
+func (collector *ScpCollector) vserverLabels(servers []vserverInformation) map[string]string {
+	labels := make(map[string]string, len(servers))
+	if collector.vserverLabel == VServerLabelName {
+		for _, server := range servers {
+			labels[server.name] = server.name
+		}
+		return labels
+	}
+	nicknames := make(map[string]int, len(servers))
+	for _, server := range servers {
+		nicknames[server.info.VServerNickname]++
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	for _, server := range servers {
+		nickname := server.info.VServerNickname
+		if nickname != "" {
+			delete(collector.nicknameWarned, server.name)
+		}
+		switch {
+		case nickname == "":
+			if collector.vserverLabel == VServerLabelNickname && !collector.nicknameWarned[server.name] {
+				collector.logger.Warn("vserver has no nickname, using its name as vserver label", "vserver", server.name)
+				collector.nicknameWarned[server.name] = true
+			}
+			labels[server.name] = server.name
+		case nicknames[nickname] > 1:
+			collector.logger.Debug("nickname is used by multiple vservers, appending the name to the vserver label", "vserver", server.name, "nickname", nickname)
+			labels[server.name] = nickname + "-" + server.name
+		default:
+			labels[server.name] = nickname
+		}
+	}
+	return labels
+}
+
+// serverState holds the attributes of a vserver whose changes are logged between collections
+type serverState struct {
+	status    string
+	rescue    bool
+	reboot    bool
+	throttled map[string]bool
+}
+
+// newServerState captures the state of a vserver from its details
+func newServerState(info *scpclient.VServerInformationObject) serverState {
+	state := serverState{
+		status:    info.Status,
+		rescue:    info.RescueEnabled,
+		reboot:    info.RebootRecommended,
+		throttled: make(map[string]bool, len(info.ServerInterfaces)),
+	}
+	for _, iface := range info.ServerInterfaces {
+		state.throttled[iface.Mac] = iface.TrafficThrottled
+	}
+	return state
+}
+
+// logStateChange logs the changes between the previous and the current state of a vserver, nothing is logged for the first collection
+func (collector *ScpCollector) logStateChange(name string, info *scpclient.VServerInformationObject) {
+	current := newServerState(info)
+	collector.mu.Lock()
+	previous, seen := collector.states[name]
+	collector.states[name] = current
+	collector.mu.Unlock()
+	if !seen {
+		return
+	}
+	if previous.status != current.status {
+		collector.logger.Info("vserver state changed", "vserver", name, "field", "status", "old", previous.status, "new", current.status)
+	}
+	if previous.rescue != current.rescue {
+		collector.logger.Info("vserver state changed", "vserver", name, "field", "rescue_active", "old", previous.rescue, "new", current.rescue)
+	}
+	if previous.reboot != current.reboot {
+		collector.logger.Info("vserver state changed", "vserver", name, "field", "reboot_recommended", "old", previous.reboot, "new", current.reboot)
+	}
+	for mac, throttled := range current.throttled {
+		if old, ok := previous.throttled[mac]; ok && old != throttled {
+			collector.logger.Info("vserver state changed", "vserver", name, "field", "interface_throttled", "mac", mac, "old", old, "new", throttled)
+		}
+	}
+}
+
+// NewScpCollector returns a collector calling client with the configuration set by opts, it fails if an option is invalid
+func NewScpCollector(client Client, logger *slog.Logger, opts ...Option) (*ScpCollector, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	constLabels := o.constLabels
 	var prefix = "scp_"
-	return &ScpCollector{
-		client:    client,
-		logger:    logger,
-		loginName: loginName,
-		password:  password,
-		cpuCores: prometheus.NewDesc(prefix+"cpu_cores",
+	monthlyTrafficLabels := []string{"vserver", "month", "year"}
+	if o.plainTrafficLabels {
+		monthlyTrafficLabels = []string{"vserver"}
+	}
+	apiRequestDurationOpts := prometheus.HistogramOpts{
+		Name:        prefix + "api_request_duration_seconds",
+		Help:        "Round-trip time of successful SCP webservice calls in seconds",
+		Buckets:     prometheus.DefBuckets,
+		ConstLabels: constLabels,
+	}
+	if o.nativeHistograms {
+		apiRequestDurationOpts.NativeHistogramBucketFactor = 1.1
+		apiRequestDurationOpts.NativeHistogramMaxBucketNumber = 100
+		apiRequestDurationOpts.NativeHistogramMinResetDuration = time.Hour
+	}
+	described := make(metricTable)
+	collector := &ScpCollector{
+		client:             client,
+		logger:             logger,
+		shapes:             o.shapes,
+		trafficHistory:     o.trafficHistory,
+		trafficGrace:       o.trafficGrace,
+		plainTrafficLabels: o.plainTrafficLabels,
+		dailyTraffic:       o.dailyTraffic,
+		includedTraffic:    o.includedTraffic,
+		filter:             o.filter,
+		vserverLabel:       o.vserverLabel,
+		logStateChanges:    o.logStateChanges,
+		rateLimits:         o.rateLimits,
+		serverListTTL:      o.serverListTTL,
+		minCollectInterval: o.minCollectInterval,
+		traffic:            make(map[string]*trafficCounter),
+		months:             make(map[string]map[int32]*scpclient.TrafficMonthObject),
+		states:             make(map[string]serverState),
+		starts:             make(map[string]time.Time),
+		nicknameWarned:     make(map[string]bool),
+		cpuCores: described.newDesc(prefix+"cpu_cores",
 			"Number of CPU cores",
 			[]string{"vserver"},
-			nil),
-		memory: prometheus.NewDesc(prefix+"memory_bytes",
+			constLabels),
+		memory: described.newDesc(prefix+"memory_bytes",
 			"Amount of Memory in Bytes",
 			[]string{"vserver"},
-			nil),
-		monthlyTrafficIn: prometheus.NewDesc(prefix+"monthlytraffic_in_bytes",
+			constLabels),
+		monthlyTrafficIn: described.newDesc(prefix+"monthlytraffic_in_bytes",
 			"Monthly traffic incoming in Bytes (only gigabyte-level resolution)",
-			[]string{"vserver", "month", "year"},
-			nil),
-		monthlyTrafficOut: prometheus.NewDesc(prefix+"monthlytraffic_out_bytes",
+			monthlyTrafficLabels,
+			constLabels),
+		monthlyTrafficOut: described.newDesc(prefix+"monthlytraffic_out_bytes",
 			"Monthly traffic outgoing in Bytes (only gigabyte-level resolution)",
-			[]string{"vserver", "month", "year"},
-			nil),
-		monthlyTrafficTotal: prometheus.NewDesc(prefix+"monthlytraffic_total_bytes",
+			monthlyTrafficLabels,
+			constLabels),
+		monthlyTrafficTotal: described.newDesc(prefix+"monthlytraffic_total_bytes",
 			"Total monthly traffic in Bytes (only gigabyte-level resolution)",
-			[]string{"vserver", "month", "year"},
-			nil),
-		serverStartTime: prometheus.NewDesc(prefix+"server_start_time_seconds",
+			monthlyTrafficLabels,
+			constLabels),
+		trafficIn: described.newDesc(prefix+"traffic_in_bytes_total",
+			"Incoming traffic in Bytes accumulated across months since the exporter started (only gigabyte-level resolution)",
+			[]string{"vserver"},
+			constLabels),
+		trafficOut: described.newDesc(prefix+"traffic_out_bytes_total",
+			"Outgoing traffic in Bytes accumulated across months since the exporter started (only gigabyte-level resolution)",
+			[]string{"vserver"},
+			constLabels),
+		dailyTrafficIn: described.newDesc(prefix+"dailytraffic_in_bytes",
+			"Daily traffic incoming in Bytes (only gigabyte-level resolution)",
+			[]string{"vserver", "date"},
+			constLabels),
+		dailyTrafficOut: described.newDesc(prefix+"dailytraffic_out_bytes",
+			"Daily traffic outgoing in Bytes (only gigabyte-level resolution)",
+			[]string{"vserver", "date"},
+			constLabels),
+		dailyTrafficTotal: described.newDesc(prefix+"dailytraffic_total_bytes",
+			"Total daily traffic in Bytes (only gigabyte-level resolution)",
+			[]string{"vserver", "date"},
+			constLabels),
+		trafficIncluded: described.newDesc(prefix+"traffic_included_bytes",
+			"Monthly traffic in Bytes included in the plan of the vserver (as configured)",
+			[]string{"vserver"},
+			constLabels),
+		trafficUsedRatio: described.newDesc(prefix+"traffic_used_ratio",
+			"Ratio of the total monthly traffic to the included traffic",
+			[]string{"vserver"},
+			constLabels),
+		serverStartTime: described.newDesc(prefix+"server_start_time_seconds",
 			"Start time of the vserver in seconds (only minute-level resolution)",
 			[]string{"vserver"},
-			nil),
-		ipInfo: prometheus.NewDesc(prefix+"ip_info", "IPs assigned to this server",
-			[]string{"vserver", "ip"},
-			nil),
-		ifaceThrottled: prometheus.NewDesc(prefix+"interface_throttled", "Interface's traffic is throttled (1) or not (0)",
-			[]string{"vserver", "driver", "id", "ip", "ip_type", "mac", "throttle_message"},
-			nil),
-		serverStatus: prometheus.NewDesc(prefix+"server_status", "Online (1) / Offline (0) status",
-			[]string{"vserver", "status", "nickname"},
-			nil),
-		rescueActive: prometheus.NewDesc(prefix+"rescue_active", "Rescue system active (1) / inactive (0)",
+			constLabels),
+		ipInfo: described.newDesc(prefix+"ip_info", "IPs assigned to this server",
+			o.shapes.ipInfoLabels(),
+			constLabels),
+		ifaceInfo: described.newDesc(prefix+"interface_info", "Network interfaces attached to this server",
+			[]string{"vserver", "mac", "driver"},
+			constLabels),
+		ifaceThrottled: described.newDesc(prefix+"interface_throttled", "Interface's traffic is throttled (1) or not (0)",
+			o.shapes.ifaceThrottledLabels(),
+			constLabels),
+		ifaceAddressInfo: described.newDesc(prefix+"interface_address_info", "IPs assigned to a network interface",
+			[]string{"vserver", "mac", "ip", "ip_type"},
+			constLabels),
+		interfacesCount: described.newDesc(prefix+"interfaces_count", "Number of network interfaces attached to this server",
+			[]string{"vserver"},
+			constLabels),
+		ipv4AddressesCount: described.newDesc(prefix+"ipv4_addresses_count", "Number of IPv4 addresses assigned to this server",
+			[]string{"vserver"},
+			constLabels),
+		ipv6PrefixesCount: described.newDesc(prefix+"ipv6_prefixes_count", "Number of IPv6 addresses / prefixes assigned to this server",
+			[]string{"vserver"},
+			constLabels),
+		serverStatus: described.newDesc(prefix+"server_status", "Online (1) / Offline (0) status",
+			o.shapes.serverStatusLabels(),
+			constLabels),
+		serverInfo: described.newDesc(prefix+"server_info", "Static attributes of the vserver",
+			[]string{"vserver", "nickname"},
+			constLabels),
+		rescueActive: described.newDesc(prefix+"rescue_active", "Rescue system active (1) / inactive (0)",
 			[]string{"vserver", "message"},
-			nil),
-		rebootRecommended: prometheus.NewDesc(prefix+"reboot_recommended", "Reboot recommended (1) / not recommended (0)",
+			constLabels),
+		rebootRecommended: described.newDesc(prefix+"reboot_recommended", "Reboot recommended (1) / not recommended (0)",
 			[]string{"vserver", "message"},
-			nil),
-		diskCapacity: prometheus.NewDesc(prefix+"disk_capacity_bytes", "Available storage space in Bytes",
+			constLabels),
+		diskCapacity: described.newDesc(prefix+"disk_capacity_bytes", "Available storage space in Bytes",
 			[]string{"vserver", "driver", "name"},
-			nil),
-		diskUsed: prometheus.NewDesc(prefix+"disk_used_bytes", "Used storage space in Bytes",
+			constLabels),
+		diskUsed: described.newDesc(prefix+"disk_used_bytes", "Used storage space in Bytes",
 			[]string{"vserver", "driver", "name"},
-			nil),
-		diskOptimization: prometheus.NewDesc(prefix+"disk_optimization", "Optimization recommended (1) / not recommended (0)",
+			constLabels),
+		diskOptimization: described.newDesc(prefix+"disk_optimization", "Optimization recommended (1) / not recommended (0)",
 			[]string{"vserver", "driver", "name", "message"},
-			nil),
+			constLabels),
+		disksCount: described.newDesc(prefix+"disks_count", "Number of disks attached to this server",
+			[]string{"vserver"},
+			constLabels),
+		disksCapacity: described.newDesc(prefix+"disks_capacity_bytes_total", "Available storage space of all disks in Bytes",
+			[]string{"vserver"},
+			constLabels),
+		disksUsed: described.newDesc(prefix+"disks_used_bytes_total", "Used storage space of all disks in Bytes",
+			[]string{"vserver"},
+			constLabels),
+		serversTotal: described.newDesc(prefix+"servers_total", "Number of vservers in the account",
+			nil,
+			constLabels),
+		serversFiltered: described.newDesc(prefix+"servers_filtered_total", "Number of vservers skipped by the include / exclude filters in the last collection",
+			nil,
+			constLabels),
+		backendInfo: described.newDesc(prefix+"exporter_backend_info", "API backend used by the exporter",
+			[]string{"backend"},
+			constLabels),
+		apiAuthOK: described.newDesc(prefix+"api_auth_ok", "Whether the webservice accepted the credentials (1) or rejected them (0), missing if it couldn't be reached",
+			nil,
+			constLabels),
+		accountInfo: described.newDesc(prefix+"account_info", "Login name of the account the webservice authenticated",
+			[]string{"login"},
+			constLabels),
+		throttledTotal: described.newDesc(prefix+"collections_throttled_total", "Number of scrapes answered with the metrics of the previous collection because it completed less than the minimum collection interval ago",
+			nil,
+			constLabels),
+		apiFailureStreak: described.newDesc(prefix+"api_consecutive_failures", "Number of consecutive collections in which the vservers couldn't be listed, 0 after a successful one",
+			nil,
+			constLabels),
+		apiCallsPerScrape: described.newDesc(prefix+"api_calls_per_scrape", "Number of SCP webservice calls issued by the last collection",
+			[]string{"endpoint"},
+			constLabels),
+		apiRequestDuration: prometheus.NewHistogramVec(apiRequestDurationOpts, []string{"endpoint"}),
+	}
+	durationDesc := make(chan *prometheus.Desc, 1)
+	collector.apiRequestDuration.Describe(durationDesc)
+	described.add(apiRequestDurationOpts.Name, <-durationDesc, []string{"endpoint"})
+	if o.rateLimits != nil {
+		maps.Copy(described, o.rateLimits.described)
+	}
+	collector.described = described
+	collector.droppedLabels, err = droppedLabelsByDesc(described, o.droppedLabels)
+	if err != nil {
+		return nil, err
+	}
+	collector.SetCredentials(o.loginName, o.password)
+	return collector, nil
+}
+
+// Register creates a collector like NewScpCollector and registers it with registerer
+func Register(registerer prometheus.Registerer, client Client, logger *slog.Logger, opts ...Option) (*ScpCollector, error) {
+	collector, err := NewScpCollector(client, logger, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := registerer.Register(collector); err != nil {
+		return nil, err
+	}
+	return collector, nil
+}
+
+// SetCredentials replaces the credentials used for the following API calls, it is safe to call during a collection
+func (collector *ScpCollector) SetCredentials(loginName string, password string) {
+	collector.credentials.Store(&credentials{loginName: loginName, password: password})
+}
+
+// authFailureLogInterval rate-limits the authentication failure message
+const authFailureLogInterval = 10 * time.Minute
+
+// IsAuthError reports whether err means that the webservice rejected the credentials.
+// The webservice answers with a SOAP fault for wrong credentials, HTTP 401 / 403 are treated the same in case a proxy is in between.
+func IsAuthError(err error) bool {
+	var fault *soap.SOAPFault
+	var httpErr *soap.HTTPError
+	switch {
+	case errors.As(err, &fault):
+		return true
+	case errors.As(err, &httpErr):
+		return httpErr.StatusCode == http.StatusUnauthorized || httpErr.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// logAuthFailure logs a hint about the credentials at most once per authFailureLogInterval
+func (collector *ScpCollector) logAuthFailure(logger *slog.Logger, err error) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if time.Since(collector.authFailureLogged) < authFailureLogInterval {
+		return
+	}
+	collector.authFailureLogged = time.Now()
+	logger.Error("authentication failed - check SCP_LOGINNAME/SCP_PASSWORD (webservice password, not CCP password)", "error", err.Error())
+}
+
+// logDebugResponse logs a webservice response as XML, it is only marshaled if debug logging is enabled
+func logDebugResponse(logger *slog.Logger, response any) {
+	if !logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	debug, _ := xml.Marshal(response)
+	logger.Debug(string(debug))
+}
+
+// FailedRequests returns the number of failed API calls since the collector was created
+func (collector *ScpCollector) FailedRequests() int64 {
+	return collector.failedRequests.Load()
+}
+
+// Check lists the vservers of the account once to verify that the webservice is reachable and accepts the credentials
+func (collector *ScpCollector) Check(ctx context.Context) (int, time.Duration, error) {
+	creds := collector.credentials.Load()
+	ctx, _ = newRequest(ctx, collector.logger)
+	start := time.Now()
+	response, err := collector.client.GetVServersContext(ctx, &scpclient.GetVServers{
+		Xmlns:     requestURL,
+		LoginName: creds.loginName,
+		Password:  creds.password,
+	})
+	latency := time.Since(start)
+	if err != nil {
+		return 0, latency, err
 	}
+	return len(response.Return_), latency, nil
+}
+
+// MetricNames returns the sorted names of all metrics the collector exports, e.g. to check generated queries against them
+func (collector *ScpCollector) MetricNames() []string {
+	return collector.described.names()
 }
 
 // Describe implements prometheus.Describe for ScpCollector
@@ -107,127 +628,641 @@ func (collector *ScpCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- collector.monthlyTrafficIn
 	ch <- collector.monthlyTrafficOut
 	ch <- collector.monthlyTrafficTotal
+	ch <- collector.trafficIn
+	ch <- collector.trafficOut
+	ch <- collector.dailyTrafficIn
+	ch <- collector.dailyTrafficOut
+	ch <- collector.dailyTrafficTotal
+	ch <- collector.trafficIncluded
+	ch <- collector.trafficUsedRatio
 	ch <- collector.serverStartTime
 	ch <- collector.ipInfo
+	ch <- collector.ifaceInfo
 	ch <- collector.ifaceThrottled
+	ch <- collector.ifaceAddressInfo
+	ch <- collector.interfacesCount
+	ch <- collector.ipv4AddressesCount
+	ch <- collector.ipv6PrefixesCount
 	ch <- collector.serverStatus
+	ch <- collector.serverInfo
 	ch <- collector.rescueActive
+	ch <- collector.rebootRecommended
 	ch <- collector.diskCapacity
 	ch <- collector.diskUsed
 	ch <- collector.diskOptimization
+	ch <- collector.disksCount
+	ch <- collector.disksCapacity
+	ch <- collector.disksUsed
+	ch <- collector.serversTotal
+	ch <- collector.serversFiltered
+	ch <- collector.backendInfo
+	ch <- collector.apiAuthOK
+	ch <- collector.accountInfo
+	ch <- collector.apiFailureStreak
+	ch <- collector.throttledTotal
+	ch <- collector.apiCallsPerScrape
+	collector.apiRequestDuration.Describe(ch)
+	if collector.rateLimits != nil {
+		collector.rateLimits.Describe(ch)
+	}
 }
 
 // Collect implements prometheus.Collect for ScpCollector
 func (collector *ScpCollector) Collect(ch chan<- prometheus.Metric) {
-	genericRequest := &scpclient.GetVServers{
-		Xmlns:     requestURL,
-		LoginName: *collector.loginName,
-		Password:  *collector.password,
+	if len(collector.droppedLabels) > 0 {
+		var done func()
+		ch, done = collector.dropLabels(ch)
+		defer done()
 	}
-	genericResponse, err := collector.client.GetVServers(genericRequest)
-	if err != nil {
-		collector.logger.Error("Unable to get servers", "error", err.Error())
+	collector.collectGuarded(ch, nil)
+	ch <- prometheus.MustNewConstMetric(collector.throttledTotal, prometheus.CounterValue, float64(collector.throttled.Load()))
+}
+
+// collectGuarded runs a collection, unless the previous one completed less than minCollectInterval ago. Then the
+// metrics of the previous collection are sent again without calling the webservice. The outcome of the webservice calls
+// is added to report unless it is nil, for a repeated collection report becomes a copy of the previous one.
+func (collector *ScpCollector) collectGuarded(ch chan<- prometheus.Metric, report *CollectionReport) {
+	if collector.minCollectInterval <= 0 {
+		collector.collect(ch, report)
+		return
+	}
+	// Held during the collection, so concurrent scrapes wait for it and get its result
+	collector.collectMu.Lock()
+	defer collector.collectMu.Unlock()
+	if !collector.lastCollected.IsZero() && time.Since(collector.lastCollected) < collector.minCollectInterval {
+		collector.throttled.Add(1)
+		collector.logger.Debug("Serving the previous collection", "age", time.Since(collector.lastCollected))
+		for _, metric := range collector.lastMetrics {
+			ch <- metric
+		}
+		if report != nil {
+			*report = *collector.lastReport
+			report.Cached = true
+		}
+		return
 	}
+	// The report is kept for debug collections answered from this one
+	if report == nil {
+		report = newCollectionReport()
+	}
+	metrics := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	var collected []prometheus.Metric
+	go func() {
+		defer close(done)
+		for metric := range metrics {
+			collected = append(collected, metric)
+			ch <- metric
+		}
+	}()
+	collector.collect(metrics, report)
+	close(metrics)
+	<-done
+	// A copy, as DebugCollect adds the samples to its report after the collection
+	last := *report
+	collector.lastMetrics, collector.lastCollected, collector.lastReport = collected, time.Now(), &last
+}
 
-	debug, _ := xml.Marshal(genericResponse)
-	collector.logger.Debug(string(debug))
+// collect runs a collection, the outcome of the webservice calls is added to report unless it is nil
+func (collector *ScpCollector) collect(ch chan<- prometheus.Metric, report *CollectionReport) {
+	if report != nil {
+		report.CollectedAt = time.Now()
+		defer func() { report.DurationSeconds = time.Since(report.CollectedAt).Seconds() }()
+	}
+	// Deferred so the rate limits reflect the responses of this collection
+	if collector.rateLimits != nil {
+		defer collector.rateLimits.Collect(ch)
+	}
+	defer collector.apiRequestDuration.Collect(ch)
+	calls := make(map[string]int)
+	defer func() {
+		for method, count := range calls {
+			ch <- prometheus.MustNewConstMetric(collector.apiCallsPerScrape, prometheus.GaugeValue, float64(count), method)
+		}
+	}()
+
+	// The SCP webservice does not report a version, only the backend in use can be exported
+	ch <- prometheus.MustNewConstMetric(collector.backendInfo, prometheus.GaugeValue, 1, "soap")
 
-	vservers := genericResponse.Return_
+	// Collect isn't passed a context, the calls are only bounded by the timeout of the client
+	c := &collection{ctx: context.Background(), calls: calls, creds: collector.credentials.Load(), logger: collector.logger.With("collection_id", newID()), report: report}
+	vservers, cached := collector.cachedServerList(c.creds)
+	if cached {
+		// Only successful listings are cached, the detail calls fail and drop the cache if the credentials are rejected
+		c.logger.Debug("Using cached server list", "count", len(vservers))
+		ch <- prometheus.MustNewConstMetric(collector.apiFailureStreak, prometheus.GaugeValue, float64(collector.consecutiveFailures.Load()))
+		ch <- prometheus.MustNewConstMetric(collector.apiAuthOK, prometheus.GaugeValue, 1)
+	} else {
+		var ok bool
+		vservers, ok = collector.listServers(ch, c)
+		if !ok {
+			return
+		}
+	}
+	collector.collectAccount(ch, c)
 
+	ch <- prometheus.MustNewConstMetric(collector.serversTotal, prometheus.GaugeValue, float64(len(vservers)))
+
+	var filtered int
+	var servers []vserverInformation
 	for _, vserver := range vservers {
+		if vserver == nil || *vserver == "" {
+			c.logger.Warn("Skipping vserver without name in listing")
+			continue
+		}
+		// The nickname is only known after the detail call, servers excluded by name are skipped before it
+		if collector.filter.excluded(*vserver) {
+			filtered++
+			c.report.server(*vserver, serverFiltered)
+			continue
+		}
 		infoRequest := &scpclient.GetVServerInformation{
 			Xmlns:       requestURL,
-			LoginName:   *collector.loginName,
-			Password:    *collector.password,
+			LoginName:   c.creds.loginName,
+			Password:    c.creds.password,
 			Vservername: *vserver,
 		}
-		infoResponse, err := collector.client.GetVServerInformation(infoRequest)
-		debug, _ := xml.Marshal(infoResponse)
-		collector.logger.Debug(string(debug))
+		ctx, logger := newRequest(c.ctx, c.logger)
+		start := time.Now()
+		infoResponse, err := collector.client.GetVServerInformationContext(ctx, infoRequest)
+		collector.recordRequest(c, "getVServerInformation", *vserver, start, err)
+		logDebugResponse(logger, infoResponse)
 		if err != nil {
-			collector.logger.Error("Unable to get Server Information", "error", err.Error())
+			logger.Error("Unable to get Server Information", "vserver", *vserver, "error", err.Error())
+			// The vserver may have been deleted or the credentials rejected, the next collection lists the vservers again
+			collector.RefreshServerList()
+			continue
+		}
+		if infoResponse.Return_ == nil {
+			logger.Warn("Skipping vserver without information", "vserver", *vserver)
+			c.report.serverFailed(*vserver, errors.New("getVServerInformation: no information"))
+			continue
 		}
-		// Create CPU / Memory info metrics
-		ch <- prometheus.MustNewConstMetric(collector.cpuCores, prometheus.GaugeValue, float64(infoResponse.Return_.CpuCores), *vserver)
-		ch <- prometheus.MustNewConstMetric(collector.memory, prometheus.GaugeValue, float64(infoResponse.Return_.Memory*1024*1024), *vserver)
+		if nickname := infoResponse.Return_.VServerNickname; collector.filter.excluded(nickname) || !collector.filter.included(*vserver, nickname) {
+			filtered++
+			c.report.server(*vserver, serverFiltered)
+			continue
+		}
+		c.report.server(*vserver, serverOK)
+		servers = append(servers, vserverInformation{name: *vserver, info: withoutNilEntries(infoResponse.Return_)})
+	}
 
-		// Create traffic metrics
-		ch <- prometheus.MustNewConstMetric(collector.monthlyTrafficIn, prometheus.GaugeValue, float64(infoResponse.Return_.CurrentMonth.In*1024*1024), *vserver, strconv.Itoa(int(infoResponse.Return_.CurrentMonth.Month)), strconv.Itoa(int(infoResponse.Return_.CurrentMonth.Year)))
-		ch <- prometheus.MustNewConstMetric(collector.monthlyTrafficOut, prometheus.GaugeValue, float64(infoResponse.Return_.CurrentMonth.Out*1024*1024), *vserver, strconv.Itoa(int(infoResponse.Return_.CurrentMonth.Month)), strconv.Itoa(int(infoResponse.Return_.CurrentMonth.Year)))
-		ch <- prometheus.MustNewConstMetric(collector.monthlyTrafficTotal, prometheus.GaugeValue, float64(infoResponse.Return_.CurrentMonth.Total*1024*1024), *vserver, strconv.Itoa(int(infoResponse.Return_.CurrentMonth.Month)), strconv.Itoa(int(infoResponse.Return_.CurrentMonth.Year)))
+	collector.forgetServers(vservers)
+	labels := collector.vserverLabels(servers)
+	for _, server := range servers {
+		collector.collectServer(ch, c, server.name, labels[server.name], server.info)
+	}
+	collector.inventory.Store(newInventory(servers))
+	c.logger.Debug("Filtered vservers", "count", filtered)
+	ch <- prometheus.MustNewConstMetric(collector.serversFiltered, prometheus.GaugeValue, float64(filtered))
+}
 
-		// Create server status metric
-		var online float64
-		if infoResponse.Return_.Status == "online" {
-			online = 1
-		}
-		ch <- prometheus.MustNewConstMetric(collector.serverStatus, prometheus.GaugeValue, online, *vserver, infoResponse.Return_.Status, infoResponse.Return_.VServerNickname)
+// recordRequest counts an API call of the current collection and records its duration, failed calls are not timed.
+// vserver is the vserver the call is about, "" for calls not related to a single vserver.
+func (collector *ScpCollector) recordRequest(c *collection, method string, vserver string, start time.Time, err error) {
+	c.calls[method]++
+	c.report.record(method, vserver, err)
+	if err != nil {
+		collector.failedRequests.Add(1)
+		return
+	}
+	collector.apiRequestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// collectMonthlyTraffic creates the monthly traffic metrics of a vserver for the given month
+func (collector *ScpCollector) collectMonthlyTraffic(ch chan<- prometheus.Metric, vserver string, year int32, month int32, traffic *scpclient.TrafficMonthObject) {
+	labels := []string{vserver}
+	if !collector.plainTrafficLabels {
+		labels = append(labels, strconv.Itoa(int(month)), strconv.Itoa(int(year)))
+	}
+	ch <- prometheus.MustNewConstMetric(collector.monthlyTrafficIn, prometheus.GaugeValue, float64(traffic.In*1024*1024), labels...)
+	ch <- prometheus.MustNewConstMetric(collector.monthlyTrafficOut, prometheus.GaugeValue, float64(traffic.Out*1024*1024), labels...)
+	ch <- prometheus.MustNewConstMetric(collector.monthlyTrafficTotal, prometheus.GaugeValue, float64(traffic.Total*1024*1024), labels...)
+}
 
-		var rescue float64
-		if infoResponse.Return_.RescueEnabled {
-			rescue = 1
+// ipType returns the address family of an IP or prefix as reported by the API
+func ipType(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// interfaceMACs maps the IPs of all interfaces, without prefix length, to the MAC of their interface
+func interfaceMACs(interfaces []*scpclient.ServerInterface) map[string]string {
+	macs := make(map[string]string)
+	for _, iface := range interfaces {
+		for _, ips := range [][]*string{iface.Ipv4IP, iface.Ipv6IP} {
+			for _, ip := range ips {
+				address, _, _ := strings.Cut(*ip, "/")
+				macs[address] = iface.Mac
+			}
 		}
-		ch <- prometheus.MustNewConstMetric(collector.rescueActive, prometheus.GaugeValue, rescue, *vserver, infoResponse.Return_.RescueEnabledMessage)
+	}
+	return macs
+}
+
+// previousMonth returns the year and month n months before the given one
+func previousMonth(year int32, month int32, n int) (int32, int32) {
+	index := year*12 + month - 1 - int32(n)
+	return index / 12, index%12 + 1
+}
+
+// startTimeResolution is the resolution of the uptime reported by the webservice
+const startTimeResolution = time.Minute
 
-		var reboot float64
-		if infoResponse.Return_.RebootRecommended {
-			reboot = 1
+// startTime derives the start time of a vserver from its uptime.
+// The previous value is kept as long as the new one is within the resolution of the uptime, so it only changes on a reboot.
+func (collector *ScpCollector) startTime(name string, uptime time.Duration) time.Time {
+	start := time.Now().Add(-uptime).Truncate(time.Second)
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if previous, ok := collector.starts[name]; ok && start.Sub(previous).Abs() < startTimeResolution {
+		return previous
+	}
+	collector.starts[name] = start
+	return start
+}
+
+// uptimeUnits maps the units used in the uptime string of the webservice to their duration
+var uptimeUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+	"week":   7 * 24 * time.Hour,
+}
+
+// parseUptimeString parses uptimes like "1 day 2 hours 3 minutes", any of the components may be missing
+func parseUptimeString(uptime *string) (parsed time.Duration, err error) {
+	fields := strings.Fields(strings.ReplaceAll(*uptime, ",", " "))
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return 0, fmt.Errorf("invalid uptime %q", *uptime)
+	}
+	for i := 0; i < len(fields); i += 2 {
+		value, err := strconv.Atoi(fields[i])
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("invalid uptime %q: %q is not a number", *uptime, fields[i])
+		}
+		unit, ok := uptimeUnits[strings.TrimSuffix(strings.ToLower(fields[i+1]), "s")]
+		if !ok {
+			return 0, fmt.Errorf("invalid uptime %q: unknown unit %q", *uptime, fields[i+1])
 		}
-		ch <- prometheus.MustNewConstMetric(collector.rebootRecommended, prometheus.GaugeValue, reboot, *vserver, infoResponse.Return_.RebootRecommendedMessage)
+		parsed += time.Duration(value) * unit
+	}
+	return parsed, nil
+}
+
+// collectServer exports the metrics of a single vserver, name is used for API calls and label as the vserver label
+func (collector *ScpCollector) collectServer(ch chan<- prometheus.Metric, c *collection, name string, label string, info *scpclient.VServerInformationObject) {
+	if collector.logStateChanges {
+		collector.logStateChange(name, info)
+	}
+
+	// Create CPU / Memory info metrics
+	ch <- prometheus.MustNewConstMetric(collector.cpuCores, prometheus.GaugeValue, float64(info.CpuCores), label)
+	ch <- prometheus.MustNewConstMetric(collector.memory, prometheus.GaugeValue, float64(info.Memory*1024*1024), label)
+
+	if info.CurrentMonth != nil {
+		collector.collectTraffic(ch, c, name, label, info.CurrentMonth)
+	} else {
+		c.logger.Warn("vserver information has no traffic of the current month", "vserver", name)
+	}
 
-		// Create IP info metric
-		for _, ip := range infoResponse.Return_.Ips {
-			ch <- prometheus.MustNewConstMetric(collector.ipInfo, prometheus.GaugeValue, 1, *vserver, *ip)
+	// Create server status metric
+	var online float64
+	if info.Status == "online" {
+		online = 1
+	}
+	ch <- prometheus.MustNewConstMetric(collector.serverStatus, prometheus.GaugeValue, online, collector.shapes.serverStatusValues(label, info)...)
+	ch <- prometheus.MustNewConstMetric(collector.serverInfo, prometheus.GaugeValue, 1, label, info.VServerNickname)
+
+	var rescue float64
+	if info.RescueEnabled {
+		rescue = 1
+	}
+	ch <- prometheus.MustNewConstMetric(collector.rescueActive, prometheus.GaugeValue, rescue, label, info.RescueEnabledMessage)
+
+	var reboot float64
+	if info.RebootRecommended {
+		reboot = 1
+	}
+	ch <- prometheus.MustNewConstMetric(collector.rebootRecommended, prometheus.GaugeValue, reboot, label, info.RebootRecommendedMessage)
+
+	// Create IP info metric
+	var ipv4Count, ipv6Count float64
+	macs := interfaceMACs(info.ServerInterfaces)
+	for _, ip := range info.Ips {
+		address, _, _ := strings.Cut(*ip, "/")
+		ch <- prometheus.MustNewConstMetric(collector.ipInfo, prometheus.GaugeValue, 1, collector.shapes.ipInfoValues(label, *ip, macs[address])...)
+		if ipType(*ip) == "ipv6" {
+			ipv6Count++
+		} else {
+			ipv4Count++
 		}
+	}
+	ch <- prometheus.MustNewConstMetric(collector.ipv4AddressesCount, prometheus.GaugeValue, ipv4Count, label)
+	ch <- prometheus.MustNewConstMetric(collector.ipv6PrefixesCount, prometheus.GaugeValue, ipv6Count, label)
+	ch <- prometheus.MustNewConstMetric(collector.interfacesCount, prometheus.GaugeValue, float64(len(info.ServerInterfaces)), label)
 
-		// Create Interface throttling metric
-		for _, iface := range infoResponse.Return_.ServerInterfaces {
-			var throttled float64
-			if iface.TrafficThrottled {
-				throttled = 1
-			}
-			seenIPs := make(map[string]bool)
-			for _, ip := range iface.Ipv4IP {
-				if _, seen := seenIPs[*ip]; !seen {
-					seenIPs[*ip] = true
-					ch <- prometheus.MustNewConstMetric(collector.ifaceThrottled, prometheus.GaugeValue, throttled, *vserver, iface.Driver, iface.Id, *ip, "ipv4", iface.Mac, iface.TrafficThrottledMessage)
+	// Create Interface metrics
+	for _, iface := range info.ServerInterfaces {
+		var throttled float64
+		if iface.TrafficThrottled {
+			throttled = 1
+		}
+		ch <- prometheus.MustNewConstMetric(collector.ifaceInfo, prometheus.GaugeValue, 1, label, iface.Mac, iface.Driver)
+		if !collector.shapes.interfaces {
+			ch <- prometheus.MustNewConstMetric(collector.ifaceThrottled, prometheus.GaugeValue, throttled, collector.shapes.ifaceThrottledValues(label, iface, "", "")...)
+		}
+		addresses := []struct {
+			ipType string
+			ips    []*string
+		}{{"ipv4", iface.Ipv4IP}, {"ipv6", iface.Ipv6IP}}
+		seenIPs := make(map[string]bool)
+		for _, address := range addresses {
+			for _, ip := range address.ips {
+				if _, seen := seenIPs[*ip]; seen {
+					continue
 				}
-			}
-			for _, ip := range iface.Ipv6IP {
-				if _, seen := seenIPs[*ip]; !seen {
-					seenIPs[*ip] = true
-					ch <- prometheus.MustNewConstMetric(collector.ifaceThrottled, prometheus.GaugeValue, throttled, *vserver, iface.Driver, iface.Id, *ip, "ipv6", iface.Mac, iface.TrafficThrottledMessage)
+				seenIPs[*ip] = true
+				ch <- prometheus.MustNewConstMetric(collector.ifaceAddressInfo, prometheus.GaugeValue, 1, label, iface.Mac, *ip, address.ipType)
+				if collector.shapes.interfaces {
+					ch <- prometheus.MustNewConstMetric(collector.ifaceThrottled, prometheus.GaugeValue, throttled, collector.shapes.ifaceThrottledValues(label, iface, *ip, address.ipType)...)
 				}
 			}
 		}
+	}
 
-		// Create Disk metrics
-		for _, disk := range infoResponse.Return_.ServerDisks {
-			ch <- prometheus.MustNewConstMetric(collector.diskCapacity, prometheus.GaugeValue, float64(disk.Capacity*1024*1024*1024), *vserver, disk.Driver, disk.Name)
-			ch <- prometheus.MustNewConstMetric(collector.diskUsed, prometheus.GaugeValue, float64(disk.Used*1024*1024*1024), *vserver, disk.Driver, disk.Name)
+	// Create Disk metrics
+	var disksCapacity, disksUsed int64
+	for _, disk := range info.ServerDisks {
+		disksCapacity += disk.Capacity
+		disksUsed += disk.Used
+		ch <- prometheus.MustNewConstMetric(collector.diskCapacity, prometheus.GaugeValue, float64(disk.Capacity*1024*1024*1024), label, disk.Driver, disk.Name)
+		ch <- prometheus.MustNewConstMetric(collector.diskUsed, prometheus.GaugeValue, float64(disk.Used*1024*1024*1024), label, disk.Driver, disk.Name)
 
-			var optimize float64
-			if disk.OptimizationRecommended {
-				optimize = 1
-			}
-			ch <- prometheus.MustNewConstMetric(collector.diskOptimization, prometheus.GaugeValue, optimize, *vserver, disk.Driver, disk.Name, disk.OptimizationRecommendedMessage)
+		var optimize float64
+		if disk.OptimizationRecommended {
+			optimize = 1
+		}
+		ch <- prometheus.MustNewConstMetric(collector.diskOptimization, prometheus.GaugeValue, optimize, label, disk.Driver, disk.Name, disk.OptimizationRecommendedMessage)
+
+	}
+	ch <- prometheus.MustNewConstMetric(collector.disksCount, prometheus.GaugeValue, float64(len(info.ServerDisks)), label)
+	ch <- prometheus.MustNewConstMetric(collector.disksCapacity, prometheus.GaugeValue, float64(disksCapacity*1024*1024*1024), label)
+	ch <- prometheus.MustNewConstMetric(collector.disksUsed, prometheus.GaugeValue, float64(disksUsed*1024*1024*1024), label)
+	// Create start time metric
+	uptime, err := parseUptimeString(&info.Uptime)
+	if err != nil {
+		c.logger.Error("Unable to parse uptime", "vserver", name, "error", err.Error())
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(collector.serverStartTime, prometheus.GaugeValue, float64(collector.startTime(name, uptime).Unix()), label)
+}
+
+// listServers lists the vservers of the account and exports the authentication status, the listing is cached if
+// WithServerListTTL is set. It returns false if the listing failed.
+func (collector *ScpCollector) listServers(ch chan<- prometheus.Metric, c *collection) ([]*string, bool) {
+	genericRequest := &scpclient.GetVServers{
+		Xmlns:     requestURL,
+		LoginName: c.creds.loginName,
+		Password:  c.creds.password,
+	}
+	ctx, logger := newRequest(c.ctx, c.logger)
+	start := time.Now()
+	genericResponse, err := collector.client.GetVServersContext(ctx, genericRequest)
+	collector.recordRequest(c, "getVServers", "", start, err)
+	failures := int64(0)
+	if err != nil {
+		failures = collector.consecutiveFailures.Add(1)
+	} else {
+		collector.consecutiveFailures.Store(0)
+	}
+	ch <- prometheus.MustNewConstMetric(collector.apiFailureStreak, prometheus.GaugeValue, float64(failures))
+	if IsAuthError(err) {
+		ch <- prometheus.MustNewConstMetric(collector.apiAuthOK, prometheus.GaugeValue, 0)
+		collector.logAuthFailure(logger, err)
+		return nil, false
+	}
+	if err != nil {
+		logger.Error("Unable to get servers", "error", err.Error())
+		return nil, false
+	}
+	ch <- prometheus.MustNewConstMetric(collector.apiAuthOK, prometheus.GaugeValue, 1)
+	collector.mu.Lock()
+	collector.authFailureLogged = time.Time{}
+	if collector.serverListTTL > 0 {
+		collector.serverList = &serverList{creds: c.creds, vservers: genericResponse.Return_, expires: time.Now().Add(collector.serverListTTL)}
+	}
+	collector.mu.Unlock()
+
+	logDebugResponse(logger, genericResponse)
+	return genericResponse.Return_, true
+}
+
+// cachedServerList returns the cached listing of the vservers of creds, if it hasn't expired
+func (collector *ScpCollector) cachedServerList(creds *credentials) ([]*string, bool) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if collector.serverList == nil || collector.serverList.creds != creds || time.Now().After(collector.serverList.expires) {
+		return nil, false
+	}
+	return collector.serverList.vservers, true
+}
 
+// RefreshServerList drops the cached listing of the vservers, the next collection lists them again
+func (collector *ScpCollector) RefreshServerList() {
+	collector.mu.Lock()
+	collector.serverList = nil
+	collector.mu.Unlock()
+}
+
+// collectAccount exports the account info, the login name is only fetched once per credentials as it can't change
+func (collector *ScpCollector) collectAccount(ch chan<- prometheus.Metric, c *collection) {
+	collector.mu.Lock()
+	account, cached := collector.account, collector.accountCreds == c.creds
+	collector.mu.Unlock()
+	if !cached {
+		userDataRequest := &scpclient.GetUserData{
+			Xmlns:     requestURL,
+			LoginName: c.creds.loginName,
+			Password:  c.creds.password,
 		}
-		// Create start time metric
-		uptime, err := parseUptimeString(&infoResponse.Return_.Uptime)
+		ctx, logger := newRequest(c.ctx, c.logger)
+		start := time.Now()
+		userDataResponse, err := collector.client.GetUserDataContext(ctx, userDataRequest)
+		collector.recordRequest(c, "getUserData", "", start, err)
 		if err != nil {
-			collector.logger.Error("Unable to parse uptime", "error", err.Error())
+			logger.Error("Unable to get account data", "error", err.Error())
+			return
 		}
-		ch <- prometheus.MustNewConstMetric(collector.serverStartTime, prometheus.GaugeValue, float64(time.Now().Add(-uptime).Unix()), *vserver)
+		// The response contains the address of the customer, only the login name is logged
+		if userDataResponse.Return_ == nil {
+			logger.Warn("Skipping account without data")
+			return
+		}
+		account = userDataResponse.Return_.Loginname
+		logger.Debug("Got account data", "login", account)
+		collector.mu.Lock()
+		collector.account, collector.accountCreds = account, c.creds
+		collector.mu.Unlock()
 	}
+	ch <- prometheus.MustNewConstMetric(collector.accountInfo, prometheus.GaugeValue, 1, account)
 }
 
-func parseUptimeString(uptime *string) (parsed time.Duration, err error) {
-	tmp := strings.Replace(*uptime, " days ", "d", 1)
-	tmp = strings.Replace(tmp, " day ", "d", 1)
-	tmp = strings.Replace(tmp, " hours ", "h", 1)
-	tmp = strings.Replace(tmp, " hour ", "h", 1)
-	tmp = strings.Replace(tmp, " minutes", "m", 1)
-	tmp = strings.Replace(tmp, " minute", "m", 1)
-	return str2duration.ParseDuration(tmp)
+// collectTraffic exports the traffic metrics of a single vserver, starting from the traffic of the current month
+func (collector *ScpCollector) collectTraffic(ch chan<- prometheus.Metric, c *collection, name string, label string, currentMonth *scpclient.TrafficMonthObject) {
+	// Create traffic metrics
+	collector.collectMonthlyTraffic(ch, label, currentMonth.Year, currentMonth.Month, currentMonth)
+	if included := collector.includedTraffic[name]; included > 0 {
+		ch <- prometheus.MustNewConstMetric(collector.trafficIncluded, prometheus.GaugeValue, float64(included), label)
+		ch <- prometheus.MustNewConstMetric(collector.trafficUsedRatio, prometheus.GaugeValue, float64(currentMonth.Total*1024*1024)/float64(included), label)
+	}
+
+	// Create traffic metrics for previous months, the last month is kept during the grace period after the rollover
+	historyMonths := collector.trafficHistory
+	monthStart := time.Date(int(currentMonth.Year), time.Month(currentMonth.Month), 1, 0, 0, 0, 0, time.Local)
+	if historyMonths == 0 && !collector.plainTrafficLabels && time.Since(monthStart) < collector.trafficGrace {
+		historyMonths = 1
+	}
+	for i := 1; i <= historyMonths; i++ {
+		year, month := previousMonth(currentMonth.Year, currentMonth.Month, i)
+		if traffic := collector.monthlyTraffic(c, name, year, month); traffic != nil {
+			collector.collectMonthlyTraffic(ch, label, year, month, traffic)
+		}
+	}
+	collector.pruneMonths(name, monthIndex(currentMonth.Year, currentMonth.Month)-int32(max(historyMonths, 1)))
+
+	// Create daily traffic metrics for today and yesterday
+	if collector.dailyTraffic {
+		today := time.Now()
+		for _, day := range []time.Time{today, today.AddDate(0, 0, -1)} {
+			trafficRequest := &scpclient.GetVServerTrafficOfDay{
+				Xmlns:       requestURL,
+				LoginName:   c.creds.loginName,
+				Password:    c.creds.password,
+				VserverName: name,
+				Year:        int32(day.Year()),
+				Month:       int32(day.Month()),
+				Day:         int32(day.Day()),
+			}
+			ctx, logger := newRequest(c.ctx, c.logger)
+			start := time.Now()
+			trafficResponse, err := collector.client.GetVServerTrafficOfDayContext(ctx, trafficRequest)
+			collector.recordRequest(c, "getVServerTrafficOfDay", name, start, err)
+			if err != nil {
+				logger.Error("Unable to get daily traffic", "vserver", name, "date", day.Format(time.DateOnly), "error", err.Error())
+				continue
+			}
+			logDebugResponse(logger, trafficResponse)
+			if trafficResponse.Return_ == nil || trafficResponse.Return_.TrafficMonthObject == nil {
+				continue
+			}
+			traffic := trafficResponse.Return_.TrafficMonthObject
+			date := day.Format(time.DateOnly)
+			ch <- prometheus.MustNewConstMetric(collector.dailyTrafficIn, prometheus.GaugeValue, float64(traffic.In*1024*1024), label, date)
+			ch <- prometheus.MustNewConstMetric(collector.dailyTrafficOut, prometheus.GaugeValue, float64(traffic.Out*1024*1024), label, date)
+			ch <- prometheus.MustNewConstMetric(collector.dailyTrafficTotal, prometheus.GaugeValue, float64(traffic.Total*1024*1024), label, date)
+		}
+	}
+
+	// Create traffic counters that keep growing across month boundaries
+	collector.mu.Lock()
+	counter, ok := collector.traffic[name]
+	if !ok {
+		counter = &trafficCounter{created: time.Now()}
+		collector.traffic[name] = counter
+	}
+	rollover, year, month := counter.rollsOver(currentMonth), counter.year, counter.month
+	collector.mu.Unlock()
+	// The traffic between the last collection of the previous month and its end would be lost otherwise
+	var final *scpclient.TrafficMonthObject
+	if rollover {
+		final = collector.monthlyTraffic(c, name, year, month)
+	}
+	collector.mu.Lock()
+	counter.update(currentMonth, final)
+	trafficIn, trafficOut := counter.value()
+	created := counter.created
+	collector.mu.Unlock()
+	ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(collector.trafficIn, prometheus.CounterValue, float64(trafficIn*1024*1024), created, label)
+	ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(collector.trafficOut, prometheus.CounterValue, float64(trafficOut*1024*1024), created, label)
+}
+
+// monthIndex numbers the months consecutively across years
+func monthIndex(year int32, month int32) int32 {
+	return year*12 + month
+}
+
+// monthlyTraffic returns the traffic of a vserver in a completed month, nil if the call failed or returned no traffic.
+// It is only fetched once, later calls are answered from the cache.
+func (collector *ScpCollector) monthlyTraffic(c *collection, name string, year int32, month int32) *scpclient.TrafficMonthObject {
+	collector.mu.Lock()
+	cached, ok := collector.months[name][monthIndex(year, month)]
+	collector.mu.Unlock()
+	if ok {
+		return cached
+	}
+	trafficRequest := &scpclient.GetVServerTrafficOfMonth{
+		Xmlns:       requestURL,
+		LoginName:   c.creds.loginName,
+		Password:    c.creds.password,
+		VserverName: name,
+		Year:        year,
+		Month:       month,
+	}
+	ctx, logger := newRequest(c.ctx, c.logger)
+	start := time.Now()
+	trafficResponse, err := collector.client.GetVServerTrafficOfMonthContext(ctx, trafficRequest)
+	collector.recordRequest(c, "getVServerTrafficOfMonth", name, start, err)
+	if err != nil {
+		logger.Error("Unable to get monthly traffic", "vserver", name, "year", year, "month", month, "error", err.Error())
+		return nil
+	}
+	logDebugResponse(logger, trafficResponse)
+	if trafficResponse.Return_ == nil {
+		return nil
+	}
+	collector.mu.Lock()
+	if collector.months[name] == nil {
+		collector.months[name] = make(map[int32]*scpclient.TrafficMonthObject)
+	}
+	collector.months[name][monthIndex(year, month)] = trafficResponse.Return_
+	collector.mu.Unlock()
+	return trafficResponse.Return_
+}
+
+// pruneMonths drops the cached traffic of a vserver in the months before oldest
+func (collector *ScpCollector) pruneMonths(name string, oldest int32) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	for index := range collector.months[name] {
+		if index < oldest {
+			delete(collector.months[name], index)
+		}
+	}
+}
+
+// forgetServers drops the traffic counters and the cached months of the vservers missing from the listing, a vserver
+// listed again later starts counting at 0
+func (collector *ScpCollector) forgetServers(vservers []*string) {
+	listed := make(map[string]bool, len(vservers))
+	for _, vserver := range vservers {
+		if vserver != nil {
+			listed[*vserver] = true
+		}
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	for name := range collector.traffic {
+		if !listed[name] {
+			delete(collector.traffic, name)
+		}
+	}
+	for name := range collector.months {
+		if !listed[name] {
+			delete(collector.months, name)
+		}
+	}
 }
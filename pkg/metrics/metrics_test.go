@@ -0,0 +1,254 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/mrueg/netcupscp-exporter/pkg/scpclient"
+)
+
+func TestParseUptimeString(t *testing.T) {
+	tests := []struct {
+		uptime string
+		want   time.Duration
+		err    bool
+	}{
+		{uptime: "1 day 2 hours 3 minutes", want: 24*time.Hour + 2*time.Hour + 3*time.Minute},
+		{uptime: "2 days 1 hour 1 minute", want: 48*time.Hour + time.Hour + time.Minute},
+		{uptime: "1 day, 2 hours, 3 minutes", want: 26*time.Hour + 3*time.Minute},
+		{uptime: "5 days", want: 5 * 24 * time.Hour},
+		{uptime: "1 hour", want: time.Hour},
+		{uptime: "12 minutes", want: 12 * time.Minute},
+		{uptime: "3 days 4 minutes", want: 72*time.Hour + 4*time.Minute},
+		{uptime: "1 week 1 day", want: 8 * 24 * time.Hour},
+		{uptime: "30 seconds", want: 30 * time.Second},
+		{uptime: "0 minutes", want: 0},
+		{uptime: "1 Day 1 Hour", want: 25 * time.Hour},
+		{uptime: "", err: true},
+		{uptime: "   ", err: true},
+		{uptime: "1 day 2", err: true},
+		{uptime: "days", err: true},
+		{uptime: "one day", err: true},
+		{uptime: "-1 day", err: true},
+		{uptime: "1 fortnight", err: true},
+		{uptime: "1.5 hours", err: true},
+		{uptime: "up since yesterday", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.uptime, func(t *testing.T) {
+			got, err := parseUptimeString(&test.uptime)
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// debugResponses returns the information of 20 vservers, the responses of a collection of a larger account
+func debugResponses() []*scpclient.GetVServerInformationResponse {
+	responses := make([]*scpclient.GetVServerInformationResponse, 20)
+	for i := range responses {
+		responses[i] = testServer(fmt.Sprintf("v%02d", i), fmt.Sprintf("web-%02d", i))
+	}
+	return responses
+}
+
+func TestLogDebugResponseDisabled(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	responses := debugResponses()
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, response := range responses {
+			logDebugResponse(logger, response)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected no allocations with debug logging disabled, got %g", allocs)
+	}
+}
+
+func BenchmarkLogDebugResponse(b *testing.B) {
+	responses := debugResponses()
+	for _, level := range []slog.Level{slog.LevelInfo, slog.LevelDebug} {
+		logger := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: level}))
+		b.Run("level="+level.String(), func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				for _, response := range responses {
+					logDebugResponse(logger, response)
+				}
+			}
+		})
+	}
+}
+
+// month returns the traffic of a month, in and out in MiB
+func month(year int32, month int32, in int64, out int64) *scpclient.TrafficMonthObject {
+	return &scpclient.TrafficMonthObject{Year: year, Month: month, In: in, Out: out, Total: in + out}
+}
+
+func TestTrafficCounterUpdate(t *testing.T) {
+	type update struct {
+		current *scpclient.TrafficMonthObject
+		final   *scpclient.TrafficMonthObject
+	}
+	tests := []struct {
+		name    string
+		updates []update
+		in      int64
+		out     int64
+	}{
+		{
+			name:    "first sight",
+			updates: []update{{current: month(2024, 10, 500, 300)}},
+		},
+		{
+			name:    "growing within the month",
+			updates: []update{{current: month(2024, 10, 500, 300)}, {current: month(2024, 10, 600, 320)}},
+			in:      100,
+			out:     20,
+		},
+		{
+			name:    "decreasing within the month",
+			updates: []update{{current: month(2024, 10, 500, 300)}, {current: month(2024, 10, 600, 320)}, {current: month(2024, 10, 550, 310)}},
+			in:      100,
+			out:     20,
+		},
+		{
+			name: "rollover with the final traffic of the previous month",
+			updates: []update{
+				{current: month(2024, 10, 500, 300)},
+				{current: month(2024, 10, 600, 320)},
+				{current: month(2024, 11, 10, 5), final: month(2024, 10, 650, 330)},
+			},
+			in:  160,
+			out: 35,
+		},
+		{
+			name: "rollover without the final traffic of the previous month",
+			updates: []update{
+				{current: month(2024, 10, 500, 300)},
+				{current: month(2024, 10, 600, 320)},
+				{current: month(2024, 11, 10, 5)},
+			},
+			in:  110,
+			out: 25,
+		},
+		{
+			name: "rollover of the year",
+			updates: []update{
+				{current: month(2024, 12, 500, 300)},
+				{current: month(2025, 1, 10, 5), final: month(2024, 12, 520, 310)},
+				{current: month(2025, 1, 40, 15)},
+			},
+			in:  60,
+			out: 25,
+		},
+		{
+			name: "late report of the previous month",
+			updates: []update{
+				{current: month(2024, 10, 500, 300)},
+				{current: month(2024, 11, 10, 5), final: month(2024, 10, 600, 320)},
+				{current: month(2024, 10, 700, 400)},
+			},
+			in:  110,
+			out: 25,
+		},
+		{
+			name: "out of order reports",
+			updates: []update{
+				{current: month(2024, 10, 500, 300)},
+				{current: month(2024, 12, 10, 5), final: month(2024, 10, 600, 320)},
+				{current: month(2024, 11, 900, 900)},
+				{current: month(2024, 12, 30, 10)},
+			},
+			in:  130,
+			out: 30,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counter := &trafficCounter{}
+			for _, update := range test.updates {
+				counter.update(update.current, update.final)
+			}
+			if in, out := counter.value(); in != test.in || out != test.out {
+				t.Errorf("expected in %d out %d, got in %d out %d", test.in, test.out, in, out)
+			}
+		})
+	}
+}
+
+// After a restart the counter of the same month starts at 0 again instead of counting the whole month as new traffic
+func TestTrafficCounterRestart(t *testing.T) {
+	counter := &trafficCounter{}
+	counter.update(month(2024, 10, 500, 300), nil)
+	counter.update(month(2024, 10, 600, 320), nil)
+	restarted := &trafficCounter{}
+	restarted.update(month(2024, 10, 600, 320), nil)
+	if in, out := restarted.value(); in != 0 || out != 0 {
+		t.Errorf("expected the restarted counter to start at 0, got in %d out %d", in, out)
+	}
+	restarted.update(month(2024, 10, 650, 330), nil)
+	if in, out := restarted.value(); in != 50 || out != 10 {
+		t.Errorf("expected in 50 out 10 after the restart, got in %d out %d", in, out)
+	}
+}
+
+func TestTrafficCounterRollsOver(t *testing.T) {
+	counter := &trafficCounter{}
+	if counter.rollsOver(month(2024, 10, 500, 300)) {
+		t.Error("expected no rollover on first sight")
+	}
+	counter.update(month(2024, 10, 500, 300), nil)
+	for _, test := range []struct {
+		current *scpclient.TrafficMonthObject
+		want    bool
+	}{
+		{month(2024, 10, 600, 300), false},
+		{month(2024, 9, 600, 300), false},
+		{month(2024, 11, 10, 5), true},
+		{month(2025, 1, 10, 5), true},
+	} {
+		if got := counter.rollsOver(test.current); got != test.want {
+			t.Errorf("rollsOver(%d-%02d) = %t, want %t", test.current.Year, test.current.Month, got, test.want)
+		}
+	}
+}
+
+func TestStartTime(t *testing.T) {
+	collector := newTestCollector(t, newFakeClient())
+	uptime := 26*time.Hour + 3*time.Minute
+	first := collector.startTime("v1", uptime)
+	// The uptime is reported in minutes, later collections derive a start time a few seconds off
+	for _, drift := range []time.Duration{3 * time.Second, -5 * time.Second, 30 * time.Second} {
+		if got := collector.startTime("v1", uptime-drift); !got.Equal(first) {
+			t.Errorf("expected the start time to stay %s with a drift of %s, got %s", first, drift, got)
+		}
+	}
+	if got := collector.startTime("v2", time.Hour); got.Equal(first) {
+		t.Error("expected every vserver to have its own start time")
+	}
+	rebooted := collector.startTime("v1", 2*time.Minute)
+	if rebooted.Sub(first) < 26*time.Hour {
+		t.Errorf("expected a reboot to move the start time, got %s after %s", rebooted, first)
+	}
+	if got := collector.startTime("v1", 2*time.Minute+time.Second); !got.Equal(rebooted) {
+		t.Errorf("expected the start time after the reboot to stay %s, got %s", rebooted, got)
+	}
+}
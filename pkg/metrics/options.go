@@ -0,0 +1,260 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// AccountLabel is the name of the label added by WithAccountLabel
+const AccountLabel = "account"
+
+// options is the configuration of a collector, set by the Option functions passed to NewScpCollector
+type options struct {
+	loginName          string
+	password           string
+	shapes             metricShapes
+	trafficHistory     int
+	trafficGrace       time.Duration
+	plainTrafficLabels bool
+	dailyTraffic       bool
+	includedTraffic    map[string]int64
+	filter             ServerFilter
+	vserverLabel       string
+	logStateChanges    bool
+	nativeHistograms   bool
+	constLabels        prometheus.Labels
+	account            string
+	rateLimits         *RateLimits
+	serverListTTL      time.Duration
+	minCollectInterval time.Duration
+	droppedLabels      map[string][]string
+}
+
+// Option configures a collector created by NewScpCollector, invalid values make NewScpCollector fail
+type Option func(*options) error
+
+// WithCredentials sets the login name and webservice password, they can be replaced later with SetCredentials
+func WithCredentials(loginName string, password string) Option {
+	return func(o *options) error {
+		o.loginName, o.password = loginName, password
+		return nil
+	}
+}
+
+// WithCompatServerStatus keeps the nickname label on scp_server_status next to scp_server_info
+func WithCompatServerStatus() Option {
+	return func(o *options) error {
+		o.shapes.serverStatus = true
+		return nil
+	}
+}
+
+// WithCompatInterfaces keeps the old shape of scp_interface_throttled with one series per IP and all interface attributes as labels
+func WithCompatInterfaces() Option {
+	return func(o *options) error {
+		o.shapes.interfaces = true
+		return nil
+	}
+}
+
+// WithCompatV0Metrics exports all metrics whose labels changed since the first release in their old shape for a
+// transition period. It implies WithCompatServerStatus and WithCompatInterfaces and drops the ip_type and mac labels of
+// scp_ip_info. Metrics added since then are still exported.
+func WithCompatV0Metrics() Option {
+	return func(o *options) error {
+		o.shapes = metricShapes{serverStatus: true, interfaces: true, ipInfo: true}
+		return nil
+	}
+}
+
+// WithTrafficHistory exports the monthly traffic of the given number of previous months next to the current one
+func WithTrafficHistory(months int) Option {
+	return func(o *options) error {
+		if months < 0 {
+			return fmt.Errorf("traffic history must not be negative, got %d months", months)
+		}
+		o.trafficHistory = months
+		return nil
+	}
+}
+
+// WithTrafficGrace exports the previous month as well during the given time after the start of a month
+func WithTrafficGrace(grace time.Duration) Option {
+	return func(o *options) error {
+		if grace < 0 {
+			return fmt.Errorf("traffic grace must not be negative, got %s", grace)
+		}
+		o.trafficGrace = grace
+		return nil
+	}
+}
+
+// WithPlainTrafficLabels exports the monthly traffic of the current month only and without month and year labels
+func WithPlainTrafficLabels() Option {
+	return func(o *options) error {
+		o.plainTrafficLabels = true
+		return nil
+	}
+}
+
+// WithDailyTraffic exports the traffic of today and yesterday as well
+func WithDailyTraffic() Option {
+	return func(o *options) error {
+		o.dailyTraffic = true
+		return nil
+	}
+}
+
+// WithIncludedTraffic sets the monthly traffic in Bytes included in the plans of the vservers, keyed by name
+func WithIncludedTraffic(included map[string]int64) Option {
+	return func(o *options) error {
+		for name, value := range included {
+			if value <= 0 {
+				return fmt.Errorf("included traffic of %s must be positive, got %d", name, value)
+			}
+		}
+		o.includedTraffic = included
+		return nil
+	}
+}
+
+// WithServerFilter selects the vservers metrics are exported for
+func WithServerFilter(filter ServerFilter) Option {
+	return func(o *options) error {
+		o.filter = filter
+		return nil
+	}
+}
+
+// WithVServerLabel selects the identifier used as vserver label, one of the VServerLabel* constants (default VServerLabelName)
+func WithVServerLabel(label string) Option {
+	return func(o *options) error {
+		switch label {
+		case VServerLabelName, VServerLabelNickname, VServerLabelNicknameFallbackName:
+			o.vserverLabel = label
+			return nil
+		}
+		return fmt.Errorf("unknown vserver label %q", label)
+	}
+}
+
+// WithNicknameLabels uses the nickname as vserver label, falling back to the name for vservers without nickname
+func WithNicknameLabels() Option {
+	return WithVServerLabel(VServerLabelNicknameFallbackName)
+}
+
+// WithStateChangeLogs logs changes of the status, rescue system, reboot recommendation and interface throttling between collections
+func WithStateChangeLogs() Option {
+	return func(o *options) error {
+		o.logStateChanges = true
+		return nil
+	}
+}
+
+// WithNativeHistograms exports scp_api_request_duration_seconds as native histogram next to the classic buckets
+func WithNativeHistograms() Option {
+	return func(o *options) error {
+		o.nativeHistograms = true
+		return nil
+	}
+}
+
+// WithConstLabels adds the labels to every metric of the collector
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(o *options) error {
+		for name := range labels {
+			if !model.LabelName(name).IsValidLegacy() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+				return fmt.Errorf("invalid const label name %q", name)
+			}
+		}
+		o.constLabels = labels
+		return nil
+	}
+}
+
+// WithAccountLabel adds an account label with the given value to every metric of the collector, so the series of
+// several accounts scraped by one Prometheus can't collide
+func WithAccountLabel(account string) Option {
+	return func(o *options) error {
+		if account == "" {
+			return errors.New("account label must not be empty")
+		}
+		o.account = account
+		return nil
+	}
+}
+
+// WithServerListTTL caches the listing of the vservers for the given time, the detail calls are still made in every
+// collection. A failed detail call or RefreshServerList drops the cache early.
+func WithServerListTTL(ttl time.Duration) Option {
+	return func(o *options) error {
+		if ttl < 0 {
+			return fmt.Errorf("server list TTL must not be negative, got %s", ttl)
+		}
+		o.serverListTTL = ttl
+		return nil
+	}
+}
+
+// WithMinCollectInterval answers scrapes with the metrics of the previous collection if it completed less than the
+// given interval ago, 0 collects on every scrape
+func WithMinCollectInterval(interval time.Duration) Option {
+	return func(o *options) error {
+		if interval < 0 {
+			return fmt.Errorf("minimum collection interval must not be negative, got %s", interval)
+		}
+		o.minCollectInterval = interval
+		return nil
+	}
+}
+
+// WithRateLimits exports the rate limit headers recorded by rateLimits at the end of every collection
+func WithRateLimits(rateLimits *RateLimits) Option {
+	return func(o *options) error {
+		o.rateLimits = rateLimits
+		return nil
+	}
+}
+
+// WithDroppedLabels exports the given labels, keyed by metric name, with an empty value. NewScpCollector fails for
+// unknown metrics and labels, of series that only differ in dropped labels only the first one is exported.
+func WithDroppedLabels(labels map[string][]string) Option {
+	return func(o *options) error {
+		o.droppedLabels = labels
+		return nil
+	}
+}
+
+// newOptions applies opts to the defaults and checks the combination of the result
+func newOptions(opts []Option) (*options, error) {
+	o := &options{vserverLabel: VServerLabelName}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if o.plainTrafficLabels && (o.trafficHistory > 0 || o.trafficGrace > 0) {
+		return nil, errors.New("plain traffic labels can't be combined with traffic history or traffic grace")
+	}
+	if o.account != "" {
+		if _, ok := o.constLabels[AccountLabel]; ok {
+			return nil, fmt.Errorf("const label %q conflicts with the account label", AccountLabel)
+		}
+		labels := make(prometheus.Labels, len(o.constLabels)+1)
+		for name, value := range o.constLabels {
+			labels[name] = value
+		}
+		labels[AccountLabel] = o.account
+		o.constLabels = labels
+	}
+	return o, nil
+}
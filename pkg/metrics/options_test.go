@@ -0,0 +1,136 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestOptions(t *testing.T) {
+	filter := ServerFilter{Include: regexp.MustCompile("web"), Exclude: regexp.MustCompile("test")}
+	rateLimits := NewRateLimits(nil)
+	tests := []struct {
+		name string
+		opts []Option
+		want func(o *options) bool
+	}{
+		{"defaults", nil, func(o *options) bool { return reflect.DeepEqual(*o, options{vserverLabel: VServerLabelName}) }},
+		{"WithCredentials", []Option{WithCredentials("123456", "secret")}, func(o *options) bool { return o.loginName == "123456" && o.password == "secret" }},
+		{"WithCompatServerStatus", []Option{WithCompatServerStatus()}, func(o *options) bool { return o.shapes == metricShapes{serverStatus: true} }},
+		{"WithCompatInterfaces", []Option{WithCompatInterfaces()}, func(o *options) bool { return o.shapes == metricShapes{interfaces: true} }},
+		{"WithCompatV0Metrics", []Option{WithCompatV0Metrics()}, func(o *options) bool {
+			return o.shapes == metricShapes{serverStatus: true, interfaces: true, ipInfo: true}
+		}},
+		{"WithTrafficHistory", []Option{WithTrafficHistory(3)}, func(o *options) bool { return o.trafficHistory == 3 }},
+		{"WithTrafficGrace", []Option{WithTrafficGrace(time.Hour)}, func(o *options) bool { return o.trafficGrace == time.Hour }},
+		{"WithPlainTrafficLabels", []Option{WithPlainTrafficLabels()}, func(o *options) bool { return o.plainTrafficLabels }},
+		{"WithDailyTraffic", []Option{WithDailyTraffic()}, func(o *options) bool { return o.dailyTraffic }},
+		{"WithIncludedTraffic", []Option{WithIncludedTraffic(map[string]int64{"v1": 1 << 40})}, func(o *options) bool { return o.includedTraffic["v1"] == 1<<40 }},
+		{"WithServerFilter", []Option{WithServerFilter(filter)}, func(o *options) bool { return o.filter == filter }},
+		{"WithVServerLabel", []Option{WithVServerLabel(VServerLabelNickname)}, func(o *options) bool { return o.vserverLabel == VServerLabelNickname }},
+		{"WithNicknameLabels", []Option{WithNicknameLabels()}, func(o *options) bool { return o.vserverLabel == VServerLabelNicknameFallbackName }},
+		{"WithStateChangeLogs", []Option{WithStateChangeLogs()}, func(o *options) bool { return o.logStateChanges }},
+		{"WithNativeHistograms", []Option{WithNativeHistograms()}, func(o *options) bool { return o.nativeHistograms }},
+		{"WithConstLabels", []Option{WithConstLabels(prometheus.Labels{"env": "prod"})}, func(o *options) bool { return o.constLabels["env"] == "prod" }},
+		{"WithAccountLabel", []Option{WithAccountLabel("customer1")}, func(o *options) bool {
+			return reflect.DeepEqual(o.constLabels, prometheus.Labels{AccountLabel: "customer1"})
+		}},
+		{"WithAccountLabel and WithConstLabels", []Option{WithConstLabels(prometheus.Labels{"env": "prod"}), WithAccountLabel("customer1")}, func(o *options) bool {
+			return reflect.DeepEqual(o.constLabels, prometheus.Labels{"env": "prod", AccountLabel: "customer1"})
+		}},
+		{"WithServerListTTL", []Option{WithServerListTTL(10 * time.Minute)}, func(o *options) bool { return o.serverListTTL == 10*time.Minute }},
+		{"WithMinCollectInterval", []Option{WithMinCollectInterval(15 * time.Second)}, func(o *options) bool { return o.minCollectInterval == 15*time.Second }},
+		{"WithRateLimits", []Option{WithRateLimits(rateLimits)}, func(o *options) bool { return o.rateLimits == rateLimits }},
+		{"WithDroppedLabels", []Option{WithDroppedLabels(map[string][]string{"scp_server_info": {"nickname"}})}, func(o *options) bool {
+			return reflect.DeepEqual(o.droppedLabels, map[string][]string{"scp_server_info": {"nickname"}})
+		}},
+		{"plain traffic labels without history", []Option{WithPlainTrafficLabels(), WithTrafficHistory(0), WithTrafficGrace(0)}, func(o *options) bool { return o.plainTrafficLabels }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o, err := newOptions(test.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !test.want(o) {
+				t.Errorf("unexpected options %+v", *o)
+			}
+		})
+	}
+}
+
+func TestOptionsConstLabelsNotModified(t *testing.T) {
+	labels := prometheus.Labels{"env": "prod"}
+	if _, err := newOptions([]Option{WithConstLabels(labels), WithAccountLabel("customer1")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := labels[AccountLabel]; ok {
+		t.Error("WithAccountLabel modified the labels passed to WithConstLabels")
+	}
+}
+
+func TestOptionsRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		err  string
+	}{
+		{"negative traffic history", []Option{WithTrafficHistory(-1)}, "traffic history must not be negative"},
+		{"negative traffic grace", []Option{WithTrafficGrace(-time.Hour)}, "traffic grace must not be negative"},
+		{"zero included traffic", []Option{WithIncludedTraffic(map[string]int64{"v1": 0})}, "included traffic of v1 must be positive"},
+		{"negative included traffic", []Option{WithIncludedTraffic(map[string]int64{"v1": -1})}, "included traffic of v1 must be positive"},
+		{"unknown vserver label", []Option{WithVServerLabel("id")}, `unknown vserver label "id"`},
+		{"empty vserver label", []Option{WithVServerLabel("")}, `unknown vserver label ""`},
+		{"invalid const label name", []Option{WithConstLabels(prometheus.Labels{"1env": "prod"})}, `invalid const label name "1env"`},
+		{"const label name with dash", []Option{WithConstLabels(prometheus.Labels{"data-center": "nbg"})}, `invalid const label name "data-center"`},
+		{"reserved const label name", []Option{WithConstLabels(prometheus.Labels{"__name__": "scp"})}, `invalid const label name "__name__"`},
+		{"empty account label", []Option{WithAccountLabel("")}, "account label must not be empty"},
+		{"negative server list TTL", []Option{WithServerListTTL(-time.Second)}, "server list TTL must not be negative"},
+		{"negative min collect interval", []Option{WithMinCollectInterval(-time.Second)}, "minimum collection interval must not be negative"},
+		{"plain traffic labels with history", []Option{WithPlainTrafficLabels(), WithTrafficHistory(1)}, "plain traffic labels can't be combined"},
+		{"plain traffic labels with grace", []Option{WithTrafficGrace(time.Hour), WithPlainTrafficLabels()}, "plain traffic labels can't be combined"},
+		{"account const label with account label", []Option{WithConstLabels(prometheus.Labels{AccountLabel: "a"}), WithAccountLabel("b")}, `const label "account" conflicts with the account label`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newOptions(test.opts)
+			if err == nil {
+				t.Fatalf("expected an error containing %q", test.err)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %q", test.err, err)
+			}
+		})
+	}
+}
+
+func TestNewScpCollectorRejectsDroppedLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		dropped map[string][]string
+		err     string
+	}{
+		{"unknown metric", map[string][]string{"scp_unknown": {"vserver"}}, "can't drop labels of unknown metric scp_unknown"},
+		{"unknown label", map[string][]string{"scp_server_info": {"name"}}, `metric scp_server_info has no label "name"`},
+		{"const label", map[string][]string{"scp_server_info": {"env"}}, `metric scp_server_info has no label "env"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewScpCollector(nil, nil, WithConstLabels(prometheus.Labels{"env": "prod"}), WithDroppedLabels(test.dropped))
+			if err == nil {
+				t.Fatalf("expected an error containing %q", test.err)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %q", test.err, err)
+			}
+		})
+	}
+}
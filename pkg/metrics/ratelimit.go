@@ -0,0 +1,96 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// resetEpochThreshold separates reset headers holding a unix timestamp from ones holding the seconds until the reset
+const resetEpochThreshold = 1e9
+
+// RateLimits holds the rate limit headers of the last webservice response, exported by collectors created WithRateLimits.
+// Both the X-RateLimit-* headers and the RateLimit-* headers of the IETF draft are understood, values missing from the
+// last response are not exported.
+type RateLimits struct {
+	limit     *prometheus.Desc
+	remaining *prometheus.Desc
+	reset     *prometheus.Desc
+	described metricTable
+
+	// mu guards the values of the last response
+	mu     sync.Mutex
+	values map[*prometheus.Desc]float64
+}
+
+// NewRateLimits returns an empty record of rate limit headers, constLabels are added to its metrics
+func NewRateLimits(constLabels prometheus.Labels) *RateLimits {
+	described := make(metricTable)
+	return &RateLimits{
+		limit: described.newDesc("scp_api_ratelimit_limit", "Number of requests allowed in the current rate limit window, as reported by the webservice",
+			nil,
+			constLabels),
+		remaining: described.newDesc("scp_api_ratelimit_remaining", "Number of requests left in the current rate limit window, as reported by the webservice",
+			nil,
+			constLabels),
+		reset: described.newDesc("scp_api_ratelimit_reset_timestamp_seconds", "Time the current rate limit window ends, as reported by the webservice",
+			nil,
+			constLabels),
+		described: described,
+		values:    make(map[*prometheus.Desc]float64),
+	}
+}
+
+// header returns the value of the first of names present in header
+func header(header http.Header, names ...string) (float64, bool) {
+	for _, name := range names {
+		if value := header.Get(name); value != "" {
+			parsed, err := strconv.ParseFloat(value, 64)
+			return parsed, err == nil
+		}
+	}
+	return 0, false
+}
+
+// Observe records the rate limit headers of a webservice response received at now
+func (r *RateLimits) Observe(h http.Header, now time.Time) {
+	values := make(map[*prometheus.Desc]float64)
+	if limit, ok := header(h, "X-RateLimit-Limit", "RateLimit-Limit"); ok {
+		values[r.limit] = limit
+	}
+	if remaining, ok := header(h, "X-RateLimit-Remaining", "RateLimit-Remaining"); ok {
+		values[r.remaining] = remaining
+	}
+	if reset, ok := header(h, "X-RateLimit-Reset", "RateLimit-Reset"); ok {
+		if reset < resetEpochThreshold {
+			reset += float64(now.Unix())
+		}
+		values[r.reset] = reset
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values = values
+}
+
+// Describe sends the descriptions of the rate limit metrics
+func (r *RateLimits) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.limit
+	ch <- r.remaining
+	ch <- r.reset
+}
+
+// Collect sends the rate limit metrics of the last response
+func (r *RateLimits) Collect(ch chan<- prometheus.Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for desc, value := range r.values {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+}
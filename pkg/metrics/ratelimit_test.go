@@ -0,0 +1,107 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRateLimitsObserve(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:    "X-RateLimit headers",
+			headers: map[string]string{"X-RateLimit-Limit": "100", "X-RateLimit-Remaining": "42", "X-RateLimit-Reset": "1700000600"},
+			want: `
+scp_api_ratelimit_limit 100
+scp_api_ratelimit_remaining 42
+scp_api_ratelimit_reset_timestamp_seconds 1.7000006e+09
+`,
+		},
+		{
+			name:    "RateLimit headers",
+			headers: map[string]string{"RateLimit-Limit": "100", "RateLimit-Remaining": "0", "RateLimit-Reset": "60"},
+			want: `
+scp_api_ratelimit_limit 100
+scp_api_ratelimit_remaining 0
+scp_api_ratelimit_reset_timestamp_seconds 1.70000006e+09
+`,
+		},
+		{
+			name:    "X-RateLimit headers take precedence",
+			headers: map[string]string{"X-RateLimit-Limit": "100", "RateLimit-Limit": "50"},
+			want: `
+scp_api_ratelimit_limit 100
+`,
+		},
+		{
+			name:    "reset just below the epoch threshold is a delta",
+			headers: map[string]string{"X-RateLimit-Reset": "999999999"},
+			want: `
+scp_api_ratelimit_reset_timestamp_seconds 2.699999999e+09
+`,
+		},
+		{
+			name:    "reset at the epoch threshold is a timestamp",
+			headers: map[string]string{"X-RateLimit-Reset": "1000000000"},
+			want: `
+scp_api_ratelimit_reset_timestamp_seconds 1e+09
+`,
+		},
+		{
+			name:    "missing headers",
+			headers: map[string]string{},
+		},
+		{
+			name:    "garbage headers",
+			headers: map[string]string{"X-RateLimit-Limit": "many", "X-RateLimit-Remaining": "42", "X-RateLimit-Reset": "tomorrow"},
+			want: `
+scp_api_ratelimit_remaining 42
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rateLimits := NewRateLimits(nil)
+			// Values of a previous response don't remain if the headers are missing from the last one
+			rateLimits.Observe(http.Header{"X-Ratelimit-Limit": {"1"}, "X-Ratelimit-Remaining": {"1"}, "X-Ratelimit-Reset": {"1"}}, now)
+			header := make(http.Header)
+			for name, value := range test.headers {
+				header.Set(name, value)
+			}
+			rateLimits.Observe(header, now)
+			if err := testutil.CollectAndCompare(rateLimits, strings.NewReader(rateLimitsExposition(test.want))); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// rateLimitsExposition adds the HELP and TYPE lines of the rate limit metrics to the samples
+func rateLimitsExposition(samples string) string {
+	help := map[string]string{
+		"scp_api_ratelimit_limit":                   "Number of requests allowed in the current rate limit window, as reported by the webservice",
+		"scp_api_ratelimit_remaining":               "Number of requests left in the current rate limit window, as reported by the webservice",
+		"scp_api_ratelimit_reset_timestamp_seconds": "Time the current rate limit window ends, as reported by the webservice",
+	}
+	var exposition strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(samples), "\n") {
+		if line == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(line, " ")
+		exposition.WriteString("# HELP " + name + " " + help[name] + "\n# TYPE " + name + " gauge\n" + line + "\n")
+	}
+	return exposition.String()
+}
@@ -0,0 +1,159 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/hooklift/gowsdl/soap"
+	"github.com/mrueg/netcupscp-exporter/pkg/fixtures"
+	"github.com/mrueg/netcupscp-exporter/pkg/metrics"
+	"github.com/mrueg/netcupscp-exporter/pkg/scpclient"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// The credentials the replay server accepts, recordings of --api.record-dir contain neither
+const replayLoginName, replayPassword = "123456", "secret"
+
+// replayedRequest is the body of a SOAP request of the collector, with the fields of all calls it makes
+type replayedRequest struct {
+	XMLName     xml.Name
+	LoginName   string `xml:"loginName"`
+	Password    string `xml:"password"`
+	Vservername string `xml:"vservername"`
+	VserverName string `xml:"vserverName"`
+	Year        int32  `xml:"year"`
+	Month       int32  `xml:"month"`
+	Day         int32  `xml:"day"`
+}
+
+// newReplayServer returns a server answering the SOAP calls of the collector from the recording in dir, as saved by
+// --api.record-dir. Calls with other credentials than replayLoginName and replayPassword get a SOAP fault like from the
+// webservice, calls without a recorded response a SOAP fault with the error.
+func newReplayServer(t *testing.T, dir string) *httptest.Server {
+	t.Helper()
+	client := fixtures.NewClient(os.DirFS(dir))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var envelope struct {
+			Body struct {
+				Request replayedRequest `xml:",any"`
+			}
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&envelope); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request := envelope.Body.Request
+		if request.LoginName != replayLoginName || request.Password != replayPassword {
+			writeSOAPFault(w, "validation error on field 'password'")
+			return
+		}
+		var response any
+		var err error
+		ctx := r.Context()
+		switch request.XMLName.Local {
+		case "getVServers":
+			response, err = client.GetVServersContext(ctx, &scpclient.GetVServers{})
+		case "getVServerInformation":
+			response, err = client.GetVServerInformationContext(ctx, &scpclient.GetVServerInformation{Vservername: request.Vservername})
+		case "getVServerTrafficOfMonth":
+			response, err = client.GetVServerTrafficOfMonthContext(ctx, &scpclient.GetVServerTrafficOfMonth{VserverName: request.VserverName, Year: request.Year, Month: request.Month})
+		case "getVServerTrafficOfDay":
+			response, err = client.GetVServerTrafficOfDayContext(ctx, &scpclient.GetVServerTrafficOfDay{VserverName: request.VserverName, Year: request.Year, Month: request.Month, Day: request.Day})
+		case "getUserData":
+			response, err = client.GetUserDataContext(ctx, &scpclient.GetUserData{})
+		default:
+			writeSOAPFault(w, "unknown operation "+request.XMLName.Local)
+			return
+		}
+		if err != nil {
+			writeSOAPFault(w, err.Error())
+			return
+		}
+		writeSOAPEnvelope(w, http.StatusOK, response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// writeSOAPEnvelope writes content as the body of a SOAP envelope
+func writeSOAPEnvelope(w http.ResponseWriter, status int, content any) {
+	body, err := xml.Marshal(content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var envelope bytes.Buffer
+	envelope.WriteString(`<?xml version="1.0" encoding="UTF-8"?><S:Envelope xmlns:S="http://schemas.xmlsoap.org/soap/envelope/"><S:Body>`)
+	envelope.Write(body)
+	envelope.WriteString(`</S:Body></S:Envelope>`)
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(envelope.Bytes())
+}
+
+// writeSOAPFault answers with a SOAP fault, which the webservice also sends for rejected credentials
+func writeSOAPFault(w http.ResponseWriter, message string) {
+	writeSOAPEnvelope(w, http.StatusInternalServerError, struct {
+		XMLName xml.Name `xml:"S:Fault"`
+		Code    string   `xml:"faultcode"`
+		String  string   `xml:"faultstring"`
+	}{Code: "S:Server", String: message})
+}
+
+// newReplayCollector returns a collector calling the replay server of the recording in dir through the SOAP client
+func newReplayCollector(t *testing.T, dir string, opts ...metrics.Option) *metrics.ScpCollector {
+	t.Helper()
+	server := newReplayServer(t, dir)
+	client := scpclient.NewWSEndUser(soap.NewClient(server.URL, soap.WithHTTPClient(server.Client())))
+	collector, err := metrics.NewScpCollector(client, testLogger(), append([]metrics.Option{metrics.WithCredentials(replayLoginName, replayPassword)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collector
+}
+
+// volatileMetrics depend on the time of the collection or the latency of the calls, they aren't compared with a golden
+// file
+var volatileMetrics = []string{"scp_api_request_duration_seconds", "scp_server_start_time_seconds"}
+
+// comparedMetrics returns the names of the metrics of the collector without volatileMetrics
+func comparedMetrics(collector *metrics.ScpCollector) []string {
+	return slices.DeleteFunc(collector.MetricNames(), func(name string) bool {
+		return slices.Contains(volatileMetrics, name)
+	})
+}
+
+func TestReplayRecording(t *testing.T) {
+	collector := newReplayCollector(t, "testdata/recording")
+	golden, err := os.Open("testdata/recording.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer golden.Close()
+	if err := testutil.CollectAndCompare(collector, golden, comparedMetrics(collector)...); err != nil {
+		t.Error(err)
+	}
+	if failed := collector.FailedRequests(); failed != 0 {
+		t.Errorf("expected no failed requests, got %d", failed)
+	}
+}
+
+func TestReplayRecordingRejectedCredentials(t *testing.T) {
+	collector := newReplayCollector(t, "testdata/recording", metrics.WithCredentials(replayLoginName, "wrong"))
+	if err := testutil.CollectAndCompare(collector, bytes.NewBufferString(`
+# HELP scp_api_auth_ok Whether the webservice accepted the credentials (1) or rejected them (0), missing if it couldn't be reached
+# TYPE scp_api_auth_ok gauge
+scp_api_auth_ok 0
+`), "scp_api_auth_ok"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,48 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+// RequestIDHeader is the HTTP header the request ID of a webservice call should be sent in
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key of the request ID of a webservice call
+type requestIDKey struct{}
+
+// RequestID returns the ID of the webservice call ctx was created for, or "" outside of a call
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newID returns a random ID for a collection or a webservice call
+func newID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// newRequest returns the context for a webservice call derived from ctx with a new request ID and logger with that ID as
+// attribute
+func newRequest(ctx context.Context, logger *slog.Logger) (context.Context, *slog.Logger) {
+	id := newID()
+	return context.WithValue(ctx, requestIDKey{}, id), logger.With("request_id", id)
+}
+
+// collection is the state of a single collection, its logger carries the collection ID and report is nil unless the
+// collection was triggered by DebugCollect. The webservice calls of the collection are derived from ctx.
+type collection struct {
+	ctx    context.Context
+	calls  map[string]int
+	creds  *credentials
+	logger *slog.Logger
+	report *CollectionReport
+}
@@ -0,0 +1,27 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package metrics
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewRequest(t *testing.T) {
+	type parentKey struct{}
+	parent, cancel := context.WithCancel(context.WithValue(context.Background(), parentKey{}, "parent"))
+	ctx, _ := newRequest(parent, testLogger())
+	other, _ := newRequest(parent, testLogger())
+	if RequestID(ctx) == "" || RequestID(ctx) == RequestID(other) {
+		t.Errorf("expected a new request ID for every call, got %q and %q", RequestID(ctx), RequestID(other))
+	}
+	if ctx.Value(parentKey{}) != "parent" {
+		t.Error("expected the values of the parent context to be kept")
+	}
+	cancel()
+	if ctx.Err() == nil {
+		t.Error("expected the call to be cancelled with the parent context")
+	}
+}
@@ -0,0 +1,191 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package remotewrite pushes gathered metrics via the Prometheus remote write protocol
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Client sends samples to a remote write endpoint
+type Client struct {
+	url    string
+	client *http.Client
+}
+
+// NewClient returns a client for the remote write endpoint at url, authenticating as configured in httpConfig
+func NewClient(url string, httpConfig config.HTTPClientConfig) (*Client, error) {
+	if err := httpConfig.Validate(); err != nil {
+		return nil, err
+	}
+	client, err := config.NewClientFromConfig(httpConfig, "remote_write")
+	if err != nil {
+		return nil, err
+	}
+	return &Client{url: url, client: client}, nil
+}
+
+// Run gathers the metrics every interval and pushes them until ctx is cancelled, errors are logged and retried with the next interval
+func Run(ctx context.Context, gatherer prometheus.Gatherer, client *Client, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		families, err := gatherer.Gather()
+		if err != nil {
+			logger.Error("Unable to gather metrics for remote write", "error", err.Error())
+		}
+		if len(families) > 0 {
+			pushCtx, cancel := context.WithTimeout(ctx, interval)
+			if err := client.Push(pushCtx, families, time.Now()); err != nil {
+				logger.Error("Unable to push metrics via remote write", "error", err.Error())
+			}
+			cancel()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Push sends the metric families as a single remote write request, samples without a timestamp get now
+func (c *Client) Push(ctx context.Context, families []*dto.MetricFamily, now time.Time) error {
+	request := encodeWriteRequest(families, now.UnixMilli())
+	body := s2.EncodeSnappy(nil, request)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote write endpoint returned %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
+
+// encodeWriteRequest builds a prometheus.WriteRequest protobuf message with one series per sample
+func encodeWriteRequest(families []*dto.MetricFamily, now int64) []byte {
+	var request []byte
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			timestamp := now
+			if metric.TimestampMs != nil {
+				timestamp = metric.GetTimestampMs()
+			}
+			for _, sample := range samples(family, metric) {
+				labels := make(map[string]string, len(metric.GetLabel())+2)
+				for _, label := range metric.GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+				for name, value := range sample.labels {
+					labels[name] = value
+				}
+				labels[model.MetricNameLabel] = sample.name
+				request = protowire.AppendTag(request, 1, protowire.BytesType)
+				request = protowire.AppendBytes(request, encodeTimeSeries(labels, sample.value, timestamp))
+			}
+		}
+	}
+	return request
+}
+
+// encodeTimeSeries builds a prometheus.TimeSeries protobuf message with the labels sorted by name
+func encodeTimeSeries(labels map[string]string, value float64, timestamp int64) []byte {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var series []byte
+	for _, name := range names {
+		var label []byte
+		label = protowire.AppendTag(label, 1, protowire.BytesType)
+		label = protowire.AppendString(label, name)
+		label = protowire.AppendTag(label, 2, protowire.BytesType)
+		label = protowire.AppendString(label, labels[name])
+		series = protowire.AppendTag(series, 1, protowire.BytesType)
+		series = protowire.AppendBytes(series, label)
+	}
+	var sample []byte
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+	sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, uint64(timestamp))
+	series = protowire.AppendTag(series, 2, protowire.BytesType)
+	return protowire.AppendBytes(series, sample)
+}
+
+// sample is a single value of a metric in the text exposition format, e.g. one histogram bucket
+type sample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// samples flattens a metric into the series it is exposed as, histograms and summaries are split like in the text format
+func samples(family *dto.MetricFamily, metric *dto.Metric) []sample {
+	name := family.GetName()
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		return []sample{{name: name, value: metric.GetCounter().GetValue()}}
+	case dto.MetricType_GAUGE:
+		return []sample{{name: name, value: metric.GetGauge().GetValue()}}
+	case dto.MetricType_UNTYPED:
+		return []sample{{name: name, value: metric.GetUntyped().GetValue()}}
+	case dto.MetricType_HISTOGRAM:
+		histogram := metric.GetHistogram()
+		var result []sample
+		for _, bucket := range histogram.GetBucket() {
+			if math.IsInf(bucket.GetUpperBound(), +1) {
+				continue
+			}
+			le := strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64)
+			result = append(result, sample{name: name + "_bucket", labels: map[string]string{model.BucketLabel: le}, value: float64(bucket.GetCumulativeCount())})
+		}
+		return append(result,
+			sample{name: name + "_bucket", labels: map[string]string{model.BucketLabel: "+Inf"}, value: float64(histogram.GetSampleCount())},
+			sample{name: name + "_sum", value: histogram.GetSampleSum()},
+			sample{name: name + "_count", value: float64(histogram.GetSampleCount())},
+		)
+	case dto.MetricType_SUMMARY:
+		summary := metric.GetSummary()
+		var result []sample
+		for _, quantile := range summary.GetQuantile() {
+			q := strconv.FormatFloat(quantile.GetQuantile(), 'g', -1, 64)
+			result = append(result, sample{name: name, labels: map[string]string{model.QuantileLabel: q}, value: quantile.GetValue()})
+		}
+		return append(result,
+			sample{name: name + "_sum", value: summary.GetSampleSum()},
+			sample{name: name + "_count", value: float64(summary.GetSampleCount())},
+		)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package remotewrite
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/config"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// testFamilies returns a gauge with unsorted labels, one sample of it with an explicit timestamp, and a histogram
+func testFamilies() []*dto.MetricFamily {
+	return []*dto.MetricFamily{
+		{
+			Name: proto.String("scp_memory_bytes"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				{
+					Label: []*dto.LabelPair{{Name: proto.String("vserver"), Value: proto.String("v1")}, {Name: proto.String("account"), Value: proto.String("a")}},
+					Gauge: &dto.Gauge{Value: proto.Float64(2048)},
+				},
+				{
+					Label:       []*dto.LabelPair{{Name: proto.String("vserver"), Value: proto.String("v2")}, {Name: proto.String("account"), Value: proto.String("a")}},
+					Gauge:       &dto.Gauge{Value: proto.Float64(4096)},
+					TimestampMs: proto.Int64(1000),
+				},
+			},
+		},
+		{
+			Name: proto.String("scp_api_request_duration_seconds"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{
+				Label: []*dto.LabelPair{{Name: proto.String("endpoint"), Value: proto.String("getVServers")}},
+				Histogram: &dto.Histogram{
+					SampleCount: proto.Uint64(3),
+					SampleSum:   proto.Float64(4.5),
+					Bucket: []*dto.Bucket{
+						{UpperBound: proto.Float64(0.5), CumulativeCount: proto.Uint64(1)},
+						{UpperBound: proto.Float64(2), CumulativeCount: proto.Uint64(2)},
+						{UpperBound: proto.Float64(math.Inf(+1)), CumulativeCount: proto.Uint64(3)},
+					},
+				},
+			}},
+		},
+	}
+}
+
+// testSeries are the series of testFamilies pushed at 5000 in the text format, with the labels in the encoded order
+var testSeries = []string{
+	`{__name__="scp_memory_bytes",account="a",vserver="v1"} 2048 @5000`,
+	`{__name__="scp_memory_bytes",account="a",vserver="v2"} 4096 @1000`,
+	`{__name__="scp_api_request_duration_seconds_bucket",endpoint="getVServers",le="0.5"} 1 @5000`,
+	`{__name__="scp_api_request_duration_seconds_bucket",endpoint="getVServers",le="2"} 2 @5000`,
+	`{__name__="scp_api_request_duration_seconds_bucket",endpoint="getVServers",le="+Inf"} 3 @5000`,
+	`{__name__="scp_api_request_duration_seconds_sum",endpoint="getVServers"} 4.5 @5000`,
+	`{__name__="scp_api_request_duration_seconds_count",endpoint="getVServers"} 3 @5000`,
+}
+
+// decodeWriteRequest decodes a prometheus.WriteRequest protobuf message into its series in the text format
+func decodeWriteRequest(t *testing.T, request []byte) []string {
+	t.Helper()
+	var series []string
+	for _, message := range fields(t, request, 1) {
+		var labels []string
+		var sample string
+		for number, values := range fieldsByNumber(t, message) {
+			switch number {
+			case 1:
+				for _, label := range values {
+					name, value := fields(t, label, 1), fields(t, label, 2)
+					labels = append(labels, fmt.Sprintf("%s=%q", name[0], value[0]))
+				}
+			case 2:
+				value, timestamp := fields(t, values[0], 1), fields(t, values[0], 2)
+				v, _ := protowire.ConsumeFixed64(value[0])
+				ts, _ := protowire.ConsumeVarint(timestamp[0])
+				sample = fmt.Sprintf("%g @%d", math.Float64frombits(v), int64(ts))
+			}
+		}
+		series = append(series, "{"+strings.Join(labels, ",")+"} "+sample)
+	}
+	return series
+}
+
+// fieldsByNumber returns the raw values of the fields of message, keyed by field number, in the order they are encoded
+func fieldsByNumber(t *testing.T, message []byte) map[protowire.Number][][]byte {
+	t.Helper()
+	byNumber := make(map[protowire.Number][][]byte)
+	for len(message) > 0 {
+		number, typ, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		message = message[n:]
+		var value []byte
+		switch typ {
+		case protowire.BytesType:
+			var m int
+			value, m = protowire.ConsumeBytes(message)
+			n = m
+		default:
+			n = protowire.ConsumeFieldValue(number, typ, message)
+			value = message[:max(n, 0)]
+		}
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		byNumber[number] = append(byNumber[number], value)
+		message = message[n:]
+	}
+	return byNumber
+}
+
+// fields returns the raw values of the field number of message
+func fields(t *testing.T, message []byte, number protowire.Number) [][]byte {
+	t.Helper()
+	return fieldsByNumber(t, message)[number]
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	got := decodeWriteRequest(t, encodeWriteRequest(testFamilies(), 5000))
+	if !slices.Equal(got, testSeries) {
+		t.Errorf("expected the series\n%s\ngot\n%s", strings.Join(testSeries, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestSamplesSummary(t *testing.T) {
+	family := &dto.MetricFamily{Name: proto.String("scp_latency_seconds"), Type: dto.MetricType_SUMMARY.Enum()}
+	metric := &dto.Metric{Summary: &dto.Summary{
+		SampleCount: proto.Uint64(2),
+		SampleSum:   proto.Float64(3),
+		Quantile:    []*dto.Quantile{{Quantile: proto.Float64(0.5), Value: proto.Float64(1)}},
+	}}
+	var got []string
+	for _, sample := range samples(family, metric) {
+		got = append(got, fmt.Sprintf("%s %v %g", sample.name, sample.labels, sample.value))
+	}
+	want := []string{"scp_latency_seconds map[quantile:0.5] 1", "scp_latency_seconds_sum map[] 3", "scp_latency_seconds_count map[] 2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected the samples %v, got %v", want, got)
+	}
+}
+
+func TestPush(t *testing.T) {
+	var series []string
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		compressed, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		request, err := s2.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("expected a snappy compressed body: %v", err)
+			return
+		}
+		series = decodeWriteRequest(t, request)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	client, err := NewClient(server.URL, config.HTTPClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Push(context.Background(), testFamilies(), time.UnixMilli(5000)); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	} {
+		if got := header.Get(name); got != want {
+			t.Errorf("expected %s %q, got %q", name, want, got)
+		}
+	}
+	if !slices.Equal(series, testSeries) {
+		t.Errorf("expected the series\n%s\ngot\n%s", strings.Join(testSeries, "\n"), strings.Join(series, "\n"))
+	}
+}
+
+func TestPushRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+	client, err := NewClient(server.URL, config.HTTPClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.Push(context.Background(), testFamilies(), time.Now())
+	if err == nil || !strings.Contains(err.Error(), "400 Bad Request: out of order sample") {
+		t.Errorf("expected the status and message of the endpoint in the error, got %v", err)
+	}
+}
@@ -217,7 +217,7 @@ type GetUserDataResponse struct {
 }
 
 type UserDataObject struct {
-	XMLName xml.Name `xml:"userDataObject"`
+	XMLName xml.Name `xml:"return"`
 
 	City string `xml:"city,omitempty" json:"city,omitempty"`
 
@@ -289,7 +289,7 @@ type VServerInformationObject struct {
 }
 
 type TrafficMonthObject struct {
-	XMLName xml.Name `xml:"currentMonth"`
+	XMLName xml.Name
 
 	In int64 `xml:"in,omitempty" json:"in,omitempty"`
 
@@ -587,17 +587,13 @@ type GetVServerTrafficOfDay struct {
 }
 
 type GetVServerTrafficOfDayResponse struct {
-	XMLName xml.Name `xml:"tns:getVServerTrafficOfDayResponse"`
-
-	Xmlns string `xml:"xmlns:tns,attr" json:"-"`
+	XMLName xml.Name `xml:"getVServerTrafficOfDayResponse"`
 
 	Return_ *TrafficDayObject `xml:"return,omitempty" json:"return,omitempty"`
 }
 
 type TrafficDayObject struct {
-	XMLName xml.Name `xml:"tns:trafficDayObject"`
-
-	Xmlns string `xml:"xmlns:tns,attr" json:"-"`
+	XMLName xml.Name `xml:"return"`
 
 	*TrafficMonthObject
 
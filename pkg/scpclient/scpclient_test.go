@@ -0,0 +1,48 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package scpclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/hooklift/gowsdl/soap"
+)
+
+// newRecordedClient returns a client whose calls are all answered with the SOAP body recorded in file
+func newRecordedClient(t *testing.T, file string) WSEndUser {
+	t.Helper()
+	body, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return NewWSEndUser(soap.NewClient(server.URL, soap.WithHTTPClient(server.Client())))
+}
+
+// TestGetUserDataDecode fails if the XMLName of UserDataObject loses its manual adjustment to "return", e.g. after
+// regenerating this package
+func TestGetUserDataDecode(t *testing.T) {
+	client := newRecordedClient(t, "testdata/getUserData.xml")
+	response, err := client.GetUserData(&GetUserData{LoginName: "123456", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Return_ == nil {
+		t.Fatal("expected the user data to be decoded")
+	}
+	if got := response.Return_.Loginname; got != "123456" {
+		t.Errorf("expected login name 123456, got %q", got)
+	}
+	if got := response.Return_.City; got != "Karlsruhe" {
+		t.Errorf("expected city Karlsruhe, got %q", got)
+	}
+}
@@ -0,0 +1,129 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mrueg/netcupscp-exporter/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/yaml.v2"
+)
+
+const probePath = "/probe"
+
+// probeConfig is the content of --config.file
+type probeConfig struct {
+	Accounts map[string]probeAccount `yaml:"accounts"`
+}
+
+// probeAccount holds the credentials of an account that can be probed via /probe?account=<name>
+type probeAccount struct {
+	LoginName    string `yaml:"login_name"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// loadProbeConfig reads the accounts from path and resolves their password files
+func loadProbeConfig(path string) (*probeConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read --config.file: %w", err)
+	}
+	config := &probeConfig{}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, fmt.Errorf("failed to parse --config.file: %w", err)
+	}
+	for name, account := range config.Accounts {
+		if account.PasswordFile != "" {
+			password, err := os.ReadFile(account.PasswordFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read password_file of account %q: %w", name, err)
+			}
+			account.Password = strings.TrimRight(string(password), "\r\n")
+			config.Accounts[name] = account
+		}
+		if account.LoginName == "" || account.Password == "" {
+			return nil, fmt.Errorf("account %q needs login_name and password or password_file", name)
+		}
+	}
+	return config, nil
+}
+
+// collectorFactory creates a collector with the options of the flags for the given credentials and extra options
+type collectorFactory func(loginName string, password string, extra ...metrics.Option) (*metrics.ScpCollector, error)
+
+// probeTarget is the collector of a configured account and the handler serving its metrics
+type probeTarget struct {
+	collector *metrics.ScpCollector
+	handler   http.Handler
+}
+
+// probeTargets are the probe targets of the configured accounts, keyed by name
+type probeTargets map[string]probeTarget
+
+// newProbeTargets creates a collector for every account of config, with the name of the account as account label.
+// The collectors of previous are reused for the accounts config still contains and only get their credentials replaced,
+// so the traffic counters, caches and the collection guard survive a reload.
+func newProbeTargets(config *probeConfig, previous probeTargets, newCollector collectorFactory) (probeTargets, error) {
+	targets := make(probeTargets, len(config.Accounts))
+	for name, account := range config.Accounts {
+		if _, ok := previous[name]; ok {
+			continue
+		}
+		collector, err := newCollector(account.LoginName, account.Password, metrics.WithAccountLabel(name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the collector of account %q: %w", name, err)
+		}
+		registry := prometheus.NewRegistry()
+		if err := registry.Register(collector); err != nil {
+			return nil, fmt.Errorf("failed to register the collector of account %q: %w", name, err)
+		}
+		targets[name] = probeTarget{
+			collector: collector,
+			handler:   promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+		}
+	}
+	// Only replaced once all new collectors could be created, a failed reload keeps the previous credentials
+	for name, account := range config.Accounts {
+		if target, ok := previous[name]; ok {
+			target.collector.SetCredentials(account.LoginName, account.Password)
+			targets[name] = target
+		}
+	}
+	return targets, nil
+}
+
+// probeHandler serves the metrics of the account passed as account (or target) parameter, collected by the collector
+// of that account. The metrics carry the name of the account as account label.
+func probeHandler(targets *atomic.Pointer[probeTargets]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("account")
+		if name == "" {
+			name = r.URL.Query().Get("target")
+		}
+		accounts := *targets.Load()
+		target, ok := accounts[name]
+		if !ok {
+			names := make([]string, 0, len(accounts))
+			for name := range accounts {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			http.Error(w, fmt.Sprintf("unknown account %q, pass one of [%s] as ?account=<name>", name, strings.Join(names, ", ")), http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Has("refresh_servers") {
+			target.collector.RefreshServerList()
+		}
+		target.handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,164 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mrueg/netcupscp-exporter/pkg/fixtures"
+	"github.com/mrueg/netcupscp-exporter/pkg/metrics"
+	"github.com/mrueg/netcupscp-exporter/pkg/scpclient"
+)
+
+// loginRecorder answers from the demo fixtures and records the login names the vservers are listed with
+type loginRecorder struct {
+	metrics.Client
+	mu     sync.Mutex
+	logins []string
+}
+
+// GetVServersContext implements metrics.Client
+func (r *loginRecorder) GetVServersContext(ctx context.Context, request *scpclient.GetVServers) (*scpclient.GetVServersResponse, error) {
+	r.mu.Lock()
+	r.logins = append(r.logins, request.LoginName)
+	r.mu.Unlock()
+	return r.Client.GetVServersContext(ctx, request)
+}
+
+// last returns the login name of the last listing
+func (r *loginRecorder) last() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.logins) == 0 {
+		return ""
+	}
+	return r.logins[len(r.logins)-1]
+}
+
+// newTestProbeTargets returns the probe targets of the accounts, collected from the demo fixtures through client
+func newTestProbeTargets(t *testing.T, client metrics.Client, config *probeConfig, previous probeTargets) probeTargets {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	targets, err := newProbeTargets(config, previous, func(loginName string, password string, extra ...metrics.Option) (*metrics.ScpCollector, error) {
+		return metrics.NewScpCollector(client, logger, append([]metrics.Option{metrics.WithCredentials(loginName, password)}, extra...)...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return targets
+}
+
+// probe requests the probe handler of targets with query and returns the status and body of the response
+func probe(t *testing.T, targets probeTargets, query string) (int, string) {
+	t.Helper()
+	var current atomic.Pointer[probeTargets]
+	current.Store(&targets)
+	recorder := httptest.NewRecorder()
+	probeHandler(&current).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, probePath+query, nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+// accountConfig returns a config file with the given accounts, keyed by name, and their login names
+func accountConfig(logins map[string]string) *probeConfig {
+	config := &probeConfig{Accounts: make(map[string]probeAccount, len(logins))}
+	for name, login := range logins {
+		config.Accounts[name] = probeAccount{LoginName: login, Password: "secret"}
+	}
+	return config
+}
+
+func TestProbeHandler(t *testing.T) {
+	client := &loginRecorder{Client: fixtures.NewClient(fixtures.Demo())}
+	targets := newTestProbeTargets(t, client, accountConfig(map[string]string{"customer1": "111111", "customer2": "222222"}), nil)
+	tests := []struct {
+		name    string
+		query   string
+		status  int
+		account string
+		login   string
+	}{
+		{"account", "?account=customer1", http.StatusOK, "customer1", "111111"},
+		{"target", "?target=customer2", http.StatusOK, "customer2", "222222"},
+		{"unknown account", "?account=customer3", http.StatusBadRequest, "", ""},
+		{"no account", "", http.StatusBadRequest, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, body := probe(t, targets, test.query)
+			if code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, code, body)
+			}
+			if test.account == "" {
+				if !strings.Contains(body, "pass one of [customer1, customer2]") {
+					t.Errorf("expected the configured accounts in the error, got %q", body)
+				}
+				return
+			}
+			// Every account has its own registry, the metrics of the others aren't served
+			for _, account := range []string{"customer1", "customer2"} {
+				if got, want := strings.Contains(body, `account="`+account+`"`), account == test.account; got != want {
+					t.Errorf("expected the metrics of %s to be served: %t", account, want)
+				}
+			}
+			if !strings.Contains(body, "scp_servers_total{") {
+				t.Errorf("expected the metrics of the account, got:\n%s", body)
+			}
+			if got := client.last(); got != test.login {
+				t.Errorf("expected the vservers to be listed as %s, got %s", test.login, got)
+			}
+		})
+	}
+}
+
+func TestNewProbeTargetsReload(t *testing.T) {
+	client := &loginRecorder{Client: fixtures.NewClient(fixtures.Demo())}
+	previous := newTestProbeTargets(t, client, accountConfig(map[string]string{"customer1": "111111", "customer2": "222222"}), nil)
+	reloaded := newTestProbeTargets(t, client, accountConfig(map[string]string{"customer1": "333333", "customer3": "444444"}), previous)
+	if len(reloaded) != 2 {
+		t.Fatalf("expected the targets of customer1 and customer3, got %d", len(reloaded))
+	}
+	if _, ok := reloaded["customer2"]; ok {
+		t.Error("expected the removed account to be dropped")
+	}
+	if reloaded["customer1"].collector != previous["customer1"].collector {
+		t.Error("expected the collector of the kept account to be reused")
+	}
+	if _, ok := reloaded["customer3"]; !ok {
+		t.Error("expected a collector for the added account")
+	}
+	if code, body := probe(t, reloaded, "?account=customer1"); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", code, body)
+	}
+	if got := client.last(); got != "333333" {
+		t.Errorf("expected the reused collector to use the reloaded credentials, got %s", got)
+	}
+}
+
+func TestNewProbeTargetsFailedReload(t *testing.T) {
+	client := &loginRecorder{Client: fixtures.NewClient(fixtures.Demo())}
+	previous := newTestProbeTargets(t, client, accountConfig(map[string]string{"customer1": "111111"}), nil)
+	_, err := newProbeTargets(accountConfig(map[string]string{"customer1": "333333", "customer2": "222222"}), previous,
+		func(loginName string, password string, extra ...metrics.Option) (*metrics.ScpCollector, error) {
+			return nil, errors.New("invalid option")
+		})
+	if err == nil || !strings.Contains(err.Error(), `account "customer2"`) {
+		t.Fatalf("expected the collector of customer2 to fail, got %v", err)
+	}
+	if _, body := probe(t, previous, "?account=customer1"); !strings.Contains(body, `account="customer1"`) {
+		t.Fatalf("expected the metrics of customer1, got:\n%s", body)
+	}
+	if got := client.last(); got != "111111" {
+		t.Errorf("expected a failed reload to keep the previous credentials, got %s", got)
+	}
+}